@@ -0,0 +1,76 @@
+package main
+
+import (
+	_ "embed"
+	"strconv"
+	"strings"
+)
+
+//go:embed port-advisories.csv
+var portAdvisoriesCSV string
+
+// portAdvisories and serviceAdvisories hold the security notes from the
+// embedded table, keyed by port and by lower-case service name
+var portAdvisories, serviceAdvisories = parseAdvisoryCSV(portAdvisoriesCSV)
+
+// Insight is a security note about an open port, reported by -insights
+type Insight struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Advisory string `json:"advisory"`
+}
+
+// parseAdvisoryCSV reads key,advisory lines, skipping comments. A key is a
+// port number or a service name. The table is embedded at build time, so
+// malformed lines are simply ignored.
+func parseAdvisoryCSV(data string) (map[int]string, map[string]string) {
+	byPort := make(map[int]string)
+	byService := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, advisory, ok := strings.Cut(line, ",")
+		key, advisory = strings.TrimSpace(key), strings.TrimSpace(advisory)
+		if !ok || key == "" || advisory == "" {
+			continue
+		}
+		if port, err := strconv.Atoi(key); err == nil {
+			byPort[port] = advisory
+		} else {
+			byService[strings.ToLower(key)] = advisory
+		}
+	}
+	return byPort, byService
+}
+
+// AdvisoryFor returns the security note for a port, falling back to the
+// service name for services found on non-standard ports. It returns "" when
+// there is nothing to say.
+func AdvisoryFor(port int, service string) string {
+	if advisory, ok := portAdvisories[port]; ok {
+		return advisory
+	}
+	return serviceAdvisories[strings.ToLower(service)]
+}
+
+// BuildInsights collects the advisories for the open ports, once per port
+// and protocol however many hosts it was open on. The result is non-nil so
+// the text report can show that insights were requested.
+func BuildInsights(ports []PortInfo) []Insight {
+	insights := make([]Insight, 0)
+	seen := make(map[PortSpec]bool)
+	for _, p := range ports {
+		key := PortSpec{Port: p.Port, Protocol: p.Protocol}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if advisory := AdvisoryFor(p.Port, p.Service); advisory != "" {
+			insights = append(insights, Insight{Port: p.Port, Protocol: p.Protocol, Service: p.Service, Advisory: advisory})
+		}
+	}
+	return insights
+}
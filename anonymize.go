@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Anonymizer replaces hostnames and addresses with pseudonyms such as
+// host-3f9a1c2e. The pseudonyms are keyed with a random per-run secret, so
+// the same host always gets the same label within a run but the labels
+// cannot be reversed by hashing candidate addresses. A nil Anonymizer
+// leaves everything unchanged.
+type Anonymizer struct {
+	key      []byte
+	replaced map[string]string
+}
+
+// NewAnonymizer creates an anonymizer with a fresh secret
+func NewAnonymizer() *Anonymizer {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &Anonymizer{key: key, replaced: make(map[string]string)}
+}
+
+// Name returns the pseudonym for a host or address. Comma-separated target
+// lists are anonymized element by element.
+func (a *Anonymizer) Name(name string) string {
+	if a == nil || name == "" {
+		return name
+	}
+	if strings.Contains(name, ",") {
+		parts := strings.Split(name, ",")
+		for i, part := range parts {
+			parts[i] = a.Name(strings.TrimSpace(part))
+		}
+		return strings.Join(parts, ",")
+	}
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(strings.ToLower(name)))
+	label := "host-" + hex.EncodeToString(mac.Sum(nil)[:4])
+	a.replaced[name] = label
+	return label
+}
+
+// names anonymizes every entry of a list in place
+func (a *Anonymizer) names(list []string) {
+	for i := range list {
+		list[i] = a.Name(list[i])
+	}
+}
+
+// Port returns a copy of an open port with its addresses anonymized
+func (a *Anonymizer) Port(port PortInfo) PortInfo {
+	if a == nil {
+		return port
+	}
+	port.IP = a.Name(port.IP)
+	port.VirtualHost = a.Name(port.VirtualHost)
+	if port.FoundOn != nil {
+		port.FoundOn = append([]string(nil), port.FoundOn...)
+		a.names(port.FoundOn)
+	}
+	return port
+}
+
+// Response anonymizes the target and every address in a scan result while
+// keeping the port data intact. Names are also scrubbed from the error.
+func (a *Anonymizer) Response(resp *ScanResponse) {
+	if a == nil {
+		return
+	}
+	resp.Target = a.Name(resp.Target)
+	a.names(resp.ResolvedIPs)
+	a.names(resp.ScannedIPs)
+	a.names(resp.DownHosts)
+	for i := range resp.OpenPorts {
+		resp.OpenPorts[i] = a.Port(resp.OpenPorts[i])
+	}
+	for i := range resp.ClosedSample {
+		resp.ClosedSample[i] = a.Port(resp.ClosedSample[i])
+	}
+	for i := range resp.SRVEndpoints {
+		resp.SRVEndpoints[i].Target = a.Name(resp.SRVEndpoints[i].Target)
+		resp.SRVEndpoints[i].Address = a.Name(resp.SRVEndpoints[i].Address)
+		resp.SRVEndpoints[i].Error = a.scrub(resp.SRVEndpoints[i].Error)
+	}
+	resp.Error = a.scrub(resp.Error)
+}
+
+// scrub replaces every name anonymized so far where it appears in free text
+func (a *Anonymizer) scrub(text string) string {
+	if text == "" {
+		return text
+	}
+	// Longest first, so 10.0.0.1 cannot clobber part of 10.0.0.15
+	names := slices.SortedFunc(maps.Keys(a.replaced), func(x, y string) int { return len(y) - len(x) })
+	for _, name := range names {
+		text = strings.ReplaceAll(text, name, a.replaced[name])
+	}
+	return text
+}
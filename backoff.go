@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Backoff strategies for the retry queue
+const (
+	// BackoffConstant waits the base delay before every retry round
+	BackoffConstant = "constant"
+	// BackoffLinear waits base, 2*base, 3*base and so on
+	BackoffLinear = "linear"
+	// BackoffExponential waits base, 2*base, 4*base and so on
+	BackoffExponential = "exponential"
+)
+
+// backoffStrategies lists the accepted strategy names
+var backoffStrategies = []string{BackoffConstant, BackoffLinear, BackoffExponential}
+
+// Retry backoff used when a request does not choose one
+const (
+	defaultBackoffStrategy = BackoffExponential
+	defaultBackoffBase     = 250 * time.Millisecond
+	defaultBackoffMax      = 5 * time.Second
+)
+
+// Backoff is the pause before each retry round, growing with the round
+// number by Strategy from Base and capped at Max. The zero Backoff never
+// waits.
+type Backoff struct {
+	Strategy string
+	Base     time.Duration
+	Max      time.Duration
+}
+
+// backoffFor builds the retry backoff of a request, filling in the defaults
+func backoffFor(req ScanRequest) Backoff {
+	b := Backoff{
+		Strategy: defaultBackoffStrategy,
+		Base:     defaultBackoffBase,
+		Max:      defaultBackoffMax,
+	}
+	if req.RetryBackoff != "" {
+		b.Strategy = req.RetryBackoff
+	}
+	if req.RetryBackoffMs > 0 {
+		b.Base = time.Duration(req.RetryBackoffMs) * time.Millisecond
+	}
+	if req.RetryBackoffMaxMs > 0 {
+		b.Max = time.Duration(req.RetryBackoffMaxMs) * time.Millisecond
+	}
+	return b
+}
+
+// validateBackoff checks the retry backoff fields of a request
+func validateBackoff(req ScanRequest) error {
+	if req.RetryBackoff != "" && !slices.Contains(backoffStrategies, req.RetryBackoff) {
+		return fmt.Errorf("retry backoff must be one of %s", strings.Join(backoffStrategies, ", "))
+	}
+	if req.RetryBackoffMs < 0 || req.RetryBackoffMaxMs < 0 {
+		return errors.New("retry backoff delays cannot be negative")
+	}
+	if b := backoffFor(req); b.Max < b.Base {
+		return fmt.Errorf("retry backoff cap %v is below the base delay %v", b.Max, b.Base)
+	}
+	return nil
+}
+
+// Delay is the pause before retry round attempt, counted from 1. Half of it
+// is fixed and half random, so scans retrying the same target at once do
+// not stay in step.
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b.Base <= 0 || attempt < 1 {
+		return 0
+	}
+	delay := b.Base
+	switch b.Strategy {
+	case BackoffLinear:
+		delay = b.Base * time.Duration(attempt)
+	case BackoffExponential:
+		for i := 1; i < attempt && delay < b.Max; i++ {
+			delay *= 2
+		}
+	}
+	if b.Max > 0 && delay > b.Max {
+		delay = b.Max
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// Wait sleeps for the delay of a retry round, returning early with the
+// context's error if it is cancelled
+func (b Backoff) Wait(ctx context.Context, attempt int) error {
+	delay := b.Delay(attempt)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
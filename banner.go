@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// defaultBannerTimeout is how long to wait for a service to speak first
+const defaultBannerTimeout = 2 * time.Second
+
+// maxBannerBytes caps how much of a greeting is kept
+const maxBannerBytes = 512
+
+// volatileBannerTokens match parts of a banner that change between
+// connections without the service changing: dates, times and time zones
+var volatileBannerTokens = []*regexp.Regexp{
+	// 2024-05-01T12:34:56Z, 2024-05-01 12:34:56.789+02:00
+	regexp.MustCompile(`\d{4}-\d{2}-\d{2}([T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?)?`),
+	// Wed, 01 May 2024 (RFC 822/1123 dates as sent by SMTP and HTTP servers)
+	regexp.MustCompile(`(?i)\b(mon|tue|wed|thu|fri|sat|sun)[a-z]*,? +\d{1,2} +[a-z]{3} +\d{2,4}\b`),
+	// Wed May  1 2024, as printed by ctime
+	regexp.MustCompile(`(?i)\b(mon|tue|wed|thu|fri|sat|sun) +[a-z]{3} +\d{1,2}\b`),
+	// 12:34:56, 12:34:56.789 PM, optionally followed by a zone
+	regexp.MustCompile(`(?i)\b\d{1,2}:\d{2}:\d{2}(\.\d+)?( ?[ap]m)?( +([+-]\d{4}|[a-z]{3,4}))?\b`),
+}
+
+// bannerProber reads whatever a service sends right after connecting and
+// checks it against the expected pattern for the port, if any
+type bannerProber struct {
+	timeout time.Duration
+	expect  map[int]*regexp.Regexp
+}
+
+// Probe implements Prober
+func (p bannerProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	if re, ok := p.expect[info.Port]; ok {
+		defer func() {
+			met := info.Banner != "" && re.MatchString(info.Banner)
+			info.ExpectationMet = &met
+		}()
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(info.Port)))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+
+	buf := make([]byte, maxBannerBytes)
+	n, _ := conn.Read(buf)
+	banner := cleanBanner(string(buf[:n]))
+	if banner == "" {
+		return
+	}
+	info.Banner = banner
+	info.BannerHash = BannerHash(banner)
+}
+
+// compileBannerExpectations compiles the expected banner pattern of each port
+func compileBannerExpectations(expect map[int]string) (map[int]*regexp.Regexp, error) {
+	if len(expect) == 0 {
+		return nil, nil
+	}
+	compiled := make(map[int]*regexp.Regexp, len(expect))
+	for port, pattern := range expect {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("expected banner for port %d: %v", port, err)
+		}
+		compiled[port] = re
+	}
+	return compiled, nil
+}
+
+// ApplyBannerExpectations records the ports whose banner did not match the
+// expected pattern, including expected ports that were not found open
+func ApplyBannerExpectations(resp *ScanResponse, expect map[int]string) {
+	open := make(map[int]bool, len(resp.OpenPorts))
+	resp.BannerMismatches = nil
+	for _, port := range resp.OpenPorts {
+		if _, ok := expect[port.Port]; !ok {
+			continue
+		}
+		open[port.Port] = true
+		if port.ExpectationMet == nil || !*port.ExpectationMet {
+			resp.BannerMismatches = append(resp.BannerMismatches, port.Port)
+		}
+	}
+	for port := range expect {
+		if !open[port] {
+			resp.BannerMismatches = append(resp.BannerMismatches, port)
+		}
+	}
+	sort.Ints(resp.BannerMismatches)
+	resp.BannerMismatches = slices.Compact(resp.BannerMismatches)
+}
+
+// expectBannerFlag collects repeated -expect-banner port:regex flags
+type expectBannerFlag map[int]string
+
+// String implements flag.Value
+func (f expectBannerFlag) String() string {
+	specs := make([]string, 0, len(f))
+	for port, pattern := range f {
+		specs = append(specs, fmt.Sprintf("%d:%s", port, pattern))
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, ",")
+}
+
+// Set implements flag.Value
+func (f expectBannerFlag) Set(value string) error {
+	portText, pattern, ok := strings.Cut(value, ":")
+	if !ok || pattern == "" {
+		return fmt.Errorf("expected port:regex, got %q", value)
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("invalid port %q", portText)
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return err
+	}
+	f[port] = pattern
+	return nil
+}
+
+// cleanBanner drops non-printable characters and surrounding whitespace
+func cleanBanner(raw string) string {
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || unicode.IsPrint(r) {
+			return r
+		}
+		return -1
+	}, strings.ToValidUTF8(raw, "")))
+}
+
+// NormalizeBanner removes volatile tokens such as timestamps and collapses
+// whitespace, so the same service version always normalizes the same way
+func NormalizeBanner(banner string) string {
+	for _, re := range volatileBannerTokens {
+		banner = re.ReplaceAllString(banner, "")
+	}
+	return strings.Join(strings.Fields(banner), " ")
+}
+
+// BannerHash is the hex SHA-256 of the normalized banner
+func BannerHash(banner string) string {
+	sum := sha256.Sum256([]byte(NormalizeBanner(banner)))
+	return hex.EncodeToString(sum[:])
+}
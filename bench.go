@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+)
+
+// benchPortCount is how many local listeners the benchmark scans
+const benchPortCount = 500
+
+// benchDialsPerLevel is the minimum number of dials measured per level
+const benchDialsPerLevel = 5000
+
+// benchLevels are the concurrency limits the benchmark steps through
+var benchLevels = []int{10, 25, 50, 100, 250, 500}
+
+// BenchLevel is the measured performance at one concurrency limit
+type BenchLevel struct {
+	Concurrency     int     `json:"concurrency"`
+	Dials           int     `json:"dials"`
+	DialsPerSecond  float64 `json:"dials_per_second"`
+	P50Ms           float64 `json:"p50_ms"`
+	P90Ms           float64 `json:"p90_ms"`
+	P99Ms           float64 `json:"p99_ms"`
+	Errors          int     `json:"errors"`
+	ErrorRate       float64 `json:"error_rate"`
+	PeakConcurrency int     `json:"peak_concurrency"`
+}
+
+// RunBenchmark measures the scan rate this machine can sustain. It opens
+// benchPortCount listeners on the loopback interface and scans them
+// repeatedly at each concurrency level; an open port that is not found open
+// counts as an error.
+func RunBenchmark(ctx context.Context, timeout time.Duration) ([]BenchLevel, error) {
+	ports := make([]int, 0, benchPortCount)
+	for range benchPortCount {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to open benchmark listener: %v", err)
+		}
+		defer listener.Close()
+		go acceptAndClose(listener)
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	slices.Sort(ports)
+	specs := portSpecs(ports, nil)
+
+	rounds := (benchDialsPerLevel + len(ports) - 1) / len(ports)
+	var levels []BenchLevel
+	for _, concurrency := range benchLevels {
+		opts := ScanOptions{MaxConcurrent: concurrency, Timeout: timeout}
+		level := BenchLevel{Concurrency: concurrency}
+		var latencies []float64
+		start := time.Now()
+		for range rounds {
+			if ctx.Err() != nil {
+				return levels, ctx.Err()
+			}
+			result := ScanPorts(ctx, "127.0.0.1", specs, opts)
+			level.Dials += len(ports)
+			level.Errors += len(ports) - result.OpenCount
+			level.PeakConcurrency = max(level.PeakConcurrency, result.PeakConcurrency)
+			for _, port := range result.OpenPorts {
+				latencies = append(latencies, port.ConnectMs)
+			}
+		}
+		elapsed := time.Since(start)
+
+		level.DialsPerSecond = float64(level.Dials) / elapsed.Seconds()
+		level.ErrorRate = float64(level.Errors) / float64(level.Dials)
+		slices.Sort(latencies)
+		level.P50Ms = percentile(latencies, 50)
+		level.P90Ms = percentile(latencies, 90)
+		level.P99Ms = percentile(latencies, 99)
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// acceptAndClose accepts connections and closes them straight away until
+// the listener is closed
+func acceptAndClose(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+}
+
+// percentile returns the p-th percentile of sorted values (nearest rank)
+func percentile(sorted []float64, p int) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// writeBenchTable prints the benchmark results as a table and names the
+// level with the highest error-free rate
+func writeBenchTable(w io.Writer, levels []BenchLevel) {
+	fmt.Fprintln(w, "CONCURRENCY  DIALS/SEC  P50 MS  P90 MS  P99 MS  ERRORS")
+	best := -1
+	for i, l := range levels {
+		fmt.Fprintf(w, "%-11d  %9.0f  %6.2f  %6.2f  %6.2f  %d (%.1f%%)\n",
+			l.Concurrency, l.DialsPerSecond, l.P50Ms, l.P90Ms, l.P99Ms, l.Errors, l.ErrorRate*100)
+		if l.Errors == 0 && (best < 0 || l.DialsPerSecond > levels[best].DialsPerSecond) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		fmt.Fprintf(w, "\nHighest error-free rate at -concurrent %d (%.0f dials/sec)\n",
+			levels[best].Concurrency, levels[best].DialsPerSecond)
+	}
+}
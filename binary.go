@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// binaryMagic starts every binary result so it can be told apart from JSON
+const binaryMagic = "PSCANBIN"
+
+// binaryVersion is bumped whenever binaryRecord changes incompatibly
+const binaryVersion = 2
+
+// binaryRecord is what a binary result holds: the response, gob encoded and
+// gzip compressed. Gob drops zero values, so a pointer to false or 0 would
+// come back as nil and an empty list as a missing one, although JSON tells
+// them apart. Kept records where those values are so the round trip is
+// lossless.
+type binaryRecord struct {
+	Version  int
+	Response ScanResponse
+	// Kept lists the paths of the pointers to zero values and the empty
+	// slices and maps in Response, as walked by gobDropped
+	Kept [][]string
+	// HostDown and Unmet are how version 1 recorded a HostUp and
+	// ExpectationMet pointing to false
+	HostDown bool
+	Unmet    []int
+}
+
+// EncodeBinary writes a scan result in the compact binary format
+func EncodeBinary(w io.Writer, resp ScanResponse) error {
+	record := binaryRecord{Version: binaryVersion, Response: resp}
+	gobDropped(reflect.ValueOf(resp), nil, &record.Kept)
+
+	if _, err := io.WriteString(w, binaryMagic); err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(record); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// DecodeBinary reads a scan result written by EncodeBinary
+func DecodeBinary(r io.Reader) (ScanResponse, error) {
+	magic := make([]byte, len(binaryMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != binaryMagic {
+		return ScanResponse{}, errors.New("not a binary scan result")
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return ScanResponse{}, err
+	}
+	defer zr.Close()
+	var record binaryRecord
+	if err := gob.NewDecoder(zr).Decode(&record); err != nil {
+		return ScanResponse{}, err
+	}
+	if record.Version < 1 || record.Version > binaryVersion {
+		return ScanResponse{}, fmt.Errorf("unsupported binary result version %d", record.Version)
+	}
+
+	resp := record.Response
+	for _, path := range record.Kept {
+		if err := restoreDropped(reflect.ValueOf(&resp).Elem(), path); err != nil {
+			return ScanResponse{}, err
+		}
+	}
+	if record.HostDown {
+		hostUp := false
+		resp.HostUp = &hostUp
+	}
+	for _, i := range record.Unmet {
+		if i >= 0 && i < len(resp.OpenPorts) {
+			met := false
+			resp.OpenPorts[i].ExpectationMet = &met
+		}
+	}
+	return resp, nil
+}
+
+// gobDropped appends the path of every value under v that gob would not
+// send but JSON would: a pointer to a zero value, or an empty but non-nil
+// slice or map. A path names struct fields, slice indexes and map keys.
+func gobDropped(v reflect.Value, path []string, kept *[][]string) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if v.Elem().IsZero() {
+			*kept = append(*kept, slices.Clone(path))
+			return
+		}
+		gobDropped(v.Elem(), path, kept)
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if field := v.Type().Field(i); field.IsExported() {
+				gobDropped(v.Field(i), append(path, field.Name), kept)
+			}
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return
+		}
+		if v.Len() == 0 {
+			*kept = append(*kept, slices.Clone(path))
+			return
+		}
+		for i := range v.Len() {
+			gobDropped(v.Index(i), append(path, strconv.Itoa(i)), kept)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return
+		}
+		if v.Len() == 0 {
+			*kept = append(*kept, slices.Clone(path))
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			gobDropped(iter.Value(), append(path, fmt.Sprint(iter.Key())), kept)
+		}
+	}
+}
+
+// restoreDropped puts back a value recorded by gobDropped: following path
+// from v, it points a nil pointer at a zero value or makes a nil slice or
+// map empty
+func restoreDropped(v reflect.Value, path []string) error {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if len(path) == 0 {
+			return nil
+		}
+		return restoreDropped(v.Elem(), path)
+	}
+	if len(path) == 0 {
+		switch v.Kind() {
+		case reflect.Slice:
+			if v.IsNil() {
+				v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+			}
+		case reflect.Map:
+			if v.IsNil() {
+				v.Set(reflect.MakeMap(v.Type()))
+			}
+		}
+		return nil
+	}
+
+	step, rest := path[0], path[1:]
+	switch v.Kind() {
+	case reflect.Struct:
+		if field := v.FieldByName(step); field.IsValid() {
+			return restoreDropped(field, rest)
+		}
+	case reflect.Slice:
+		if i, err := strconv.Atoi(step); err == nil && i >= 0 && i < v.Len() {
+			return restoreDropped(v.Index(i), rest)
+		}
+	case reflect.Map:
+		// Map values cannot be set in place, so a copy is restored and
+		// stored back
+		iter := v.MapRange()
+		for iter.Next() {
+			if fmt.Sprint(iter.Key()) == step {
+				value := reflect.New(v.Type().Elem()).Elem()
+				value.Set(iter.Value())
+				if err := restoreDropped(value, rest); err != nil {
+					return err
+				}
+				v.SetMapIndex(iter.Key(), value)
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("binary result records a missing value at %s", strings.Join(path, "."))
+}
+
+// SaveBinary writes a scan result to a file in the binary format, replacing
+// the file atomically
+func SaveBinary(path string, resp ScanResponse) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		if err := EncodeBinary(bw, resp); err != nil {
+			return err
+		}
+		return bw.Flush()
+	})
+}
+
+// LoadBinary reads a scan result saved by SaveBinary
+func LoadBinary(path string) (ScanResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResponse{}, err
+	}
+	defer f.Close()
+	resp, err := DecodeBinary(bufio.NewReader(f))
+	if err != nil {
+		return ScanResponse{}, fmt.Errorf("%s: invalid binary scan result: %v", path, err)
+	}
+	return resp, nil
+}
+
+// isBinaryResult reports whether saved data is in the binary format
+func isBinaryResult(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(binaryMagic))
+}
+
+// writeBinaryReport renders the results in the binary format for -out
+func writeBinaryReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	return EncodeBinary(w, resp)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// binaryRoundTrip encodes and decodes a response in the binary format
+func binaryRoundTrip(t *testing.T, resp ScanResponse) ScanResponse {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := EncodeBinary(&buf, resp); err != nil {
+		t.Fatalf("EncodeBinary: %v", err)
+	}
+	decoded, err := DecodeBinary(&buf)
+	if err != nil {
+		t.Fatalf("DecodeBinary: %v", err)
+	}
+	return decoded
+}
+
+// assertSameJSON fails unless two responses have the same JSON encoding
+func assertSameJSON(t *testing.T, got, want ScanResponse) {
+	t.Helper()
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("round trip changed the result\ngot  %s\nwant %s", gotJSON, wantJSON)
+	}
+}
+
+func TestBinaryRoundTripIsLossless(t *testing.T) {
+	hostUp, met, unmet := false, true, false
+	zeroScale, scale := 0, 7
+	resp := ScanResponse{
+		Target:    "example.com",
+		StartPort: 1,
+		EndPort:   1024,
+		OpenPorts: []PortInfo{
+			{Port: 22, Protocol: protoTCP, Service: "SSH", State: "open", ExpectationMet: &met,
+				TCPInfo: &TCPInfo{MSS: 1460, WindowScale: &zeroScale}},
+			{Port: 80, Protocol: protoTCP, Service: "HTTP", State: "open", ExpectationMet: &unmet,
+				TCPInfo: &TCPInfo{MSS: 1460, WindowScale: &scale}, FoundOn: []string{"192.0.2.1"}},
+			{Port: 443, State: "open", TCPInfo: &TCPInfo{}},
+		},
+		HostUp:     &hostUp,
+		TotalPorts: 1024,
+		Categories: map[string][]int{"web": {80}, "mail": {}},
+		Timestamp:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Status:     StatusComplete,
+	}
+	assertSameJSON(t, binaryRoundTrip(t, resp), resp)
+}
+
+func TestBinaryRoundTripKeepsEmptyLists(t *testing.T) {
+	// No open ports encodes as [] in JSON, not null
+	resp := ScanResponse{Target: "192.0.2.1", OpenPorts: []PortInfo{}, Status: StatusComplete}
+	assertSameJSON(t, binaryRoundTrip(t, resp), resp)
+
+	resp.OpenPorts = nil
+	assertSameJSON(t, binaryRoundTrip(t, resp), resp)
+}
+
+func TestDecodeBinaryVersion1(t *testing.T) {
+	hostUp, unmet := false, false
+	want := ScanResponse{
+		Target:    "192.0.2.1",
+		OpenPorts: []PortInfo{{Port: 22, State: "open", ExpectationMet: &unmet}},
+		HostUp:    &hostUp,
+	}
+
+	// Version 1 recorded false pointers in HostDown and Unmet
+	var buf bytes.Buffer
+	buf.WriteString(binaryMagic)
+	zw := gzip.NewWriter(&buf)
+	record := binaryRecord{Version: 1, Response: want, HostDown: true, Unmet: []int{0}}
+	if err := gob.NewEncoder(zw).Encode(record); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	got, err := DecodeBinary(&buf)
+	if err != nil {
+		t.Fatalf("DecodeBinary: %v", err)
+	}
+	assertSameJSON(t, got, want)
+}
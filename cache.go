@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultCache keeps recent web scan results so that an identical request
+// made within the TTL is answered without scanning again. Entries are
+// dropped once they expire. A nil cache stores nothing.
+type resultCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cachedResult
+}
+
+// cachedResult is a cached response and when it stops being served
+type cachedResult struct {
+	response ScanResponse
+	expires  time.Time
+}
+
+// newResultCache creates a cache with the given TTL, or returns nil when
+// the TTL is zero so caching is off
+func newResultCache(ttl time.Duration) *resultCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &resultCache{ttl: ttl, entries: make(map[string]cachedResult)}
+}
+
+// cacheKey identifies a request after normalizing the parts that do not
+// change what is scanned: the case and spacing of the host and the order of
+// the port lists
+func cacheKey(req ScanRequest) string {
+	req.Host = strings.ToLower(strings.TrimSpace(req.Host))
+	req.Ports = slices.Compact(slices.Sorted(slices.Values(req.Ports)))
+	req.UDPPorts = slices.Compact(slices.Sorted(slices.Values(req.UDPPorts)))
+	// Map keys are marshalled in sorted order, so the encoding is stable
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached response for a key, marked as cached, if it has
+// not expired
+func (c *resultCache) Get(key string) (ScanResponse, bool) {
+	if c == nil {
+		return ScanResponse{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return ScanResponse{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return ScanResponse{}, false
+	}
+	response := entry.response
+	response.Cached = true
+	return response, true
+}
+
+// Put caches a response for the TTL. Only complete scans are cached, so a
+// cancelled or failed scan is retried on the next request. Expired entries
+// are swept at the same time to keep the cache bounded by the request rate.
+func (c *resultCache) Put(key string, response ScanResponse) {
+	if c == nil || response.Status != StatusComplete {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cachedResult{response: response, expires: now.Add(c.ttl)}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"cmp"
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed port-categories.csv
+var portCategoriesCSV string
+
+// categoryOther is the category of ports missing from the table
+const categoryOther = "other"
+
+// portCategories maps ports to service categories, loaded from the embedded table
+var portCategories = parseCategoryCSV(portCategoriesCSV)
+
+// categoryOrder is the order categories are listed in grouped output
+var categoryOrder = []string{"web", "database", "remote-access", "mail", categoryOther}
+
+// parseCategoryCSV reads port,category lines, skipping comments. The table
+// is embedded at build time, so malformed lines are simply ignored.
+func parseCategoryCSV(data string) map[int]string {
+	categories := make(map[int]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		portField, category, ok := strings.Cut(line, ",")
+		port, err := strconv.Atoi(strings.TrimSpace(portField))
+		if !ok || err != nil {
+			continue
+		}
+		categories[port] = strings.TrimSpace(category)
+	}
+	return categories
+}
+
+// CategoryOf returns the service category of a port: web, database,
+// remote-access, mail or other
+func CategoryOf(port int) string {
+	if category, ok := portCategories[port]; ok {
+		return category
+	}
+	return categoryOther
+}
+
+// GroupByCategory maps each category to its open ports in ascending order
+func GroupByCategory(ports []PortInfo) map[string][]int {
+	groups := make(map[string][]int)
+	for _, p := range ports {
+		category := CategoryOf(p.Port)
+		groups[category] = append(groups[category], p.Port)
+	}
+	for _, list := range groups {
+		sort.Ints(list)
+	}
+	return groups
+}
+
+// SummarizeByService counts the open ports of a result by service name.
+// Every host counts separately, so a multi-host scan gives the aggregate
+// service footprint; ports without a name count as "unknown".
+func SummarizeByService(resp ScanResponse) map[string]int {
+	counts := make(map[string]int)
+	for _, p := range resp.OpenPorts {
+		counts[cmp.Or(p.Service, "unknown")]++
+	}
+	return counts
+}
+
+// formatServiceCounts renders counts such as "3× HTTP, 2× SSH", most common
+// first
+func formatServiceCounts(counts map[string]int) string {
+	services := make([]string, 0, len(counts))
+	for service := range counts {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if counts[services[i]] != counts[services[j]] {
+			return counts[services[i]] > counts[services[j]]
+		}
+		return services[i] < services[j]
+	})
+	parts := make([]string, len(services))
+	for i, service := range services {
+		parts[i] = fmt.Sprintf("%d× %s", counts[service], service)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+)
+
+// defaultDeltaSample is how many ports that were closed in the baseline a
+// delta scan re-checks
+const defaultDeltaSample = 50
+
+// PlanDelta narrows a request to a quick re-check against a baseline: every
+// selected port that was open in the baseline, plus up to sample of the
+// other selected ports picked at random. It also returns the baseline cut
+// down to the ports it re-checks, for comparing with the result.
+func PlanDelta(req ScanRequest, baseline ScanResponse, sample int) (ScanRequest, ScanResponse, error) {
+	selected := portSpecs(ResolvePorts(req), req.UDPPorts)
+	wasOpen := make(map[PortSpec]bool, len(baseline.OpenPorts))
+	for _, p := range baseline.OpenPorts {
+		wasOpen[PortSpec{Port: p.Port, Protocol: cmpProtocol(p.Protocol)}] = true
+	}
+
+	var recheck, closed []PortSpec
+	for _, spec := range selected {
+		if wasOpen[spec] {
+			recheck = append(recheck, spec)
+		} else {
+			closed = append(closed, spec)
+		}
+	}
+	rand.Shuffle(len(closed), func(i, j int) { closed[i], closed[j] = closed[j], closed[i] })
+	recheck = append(recheck, closed[:min(sample, len(closed))]...)
+	if len(recheck) == 0 {
+		return req, baseline, errors.New("nothing to re-check: the baseline has no open ports in the selection and the sample is empty")
+	}
+
+	tcp, udp := splitProtocols(recheck)
+	slices.Sort(tcp)
+	req.Ports = tcp
+	req.UDPPorts = nil
+	for _, spec := range udp {
+		req.UDPPorts = append(req.UDPPorts, spec.Port)
+	}
+	slices.Sort(req.UDPPorts)
+	// The selection is already applied
+	req.KnownOnly, req.AlsoPorts, req.ExcludePorts = false, nil, nil
+
+	kept := baseline
+	kept.OpenPorts = nil
+	for _, p := range baseline.OpenPorts {
+		if slices.Contains(recheck, PortSpec{Port: p.Port, Protocol: cmpProtocol(p.Protocol)}) {
+			kept.OpenPorts = append(kept.OpenPorts, p)
+		}
+	}
+	return req, kept, nil
+}
+
+// cmpProtocol is a port's protocol for matching, TCP for results saved
+// before the protocol was recorded
+func cmpProtocol(protocol string) string {
+	if protocol == "" {
+		return protoTCP
+	}
+	return protocol
+}
+
+// writeDeltaReport lists the baseline ports that are no longer open and
+// the sampled ports that newly are
+func writeDeltaReport(w io.Writer, path string, baseline, resp ScanResponse) {
+	diff := DiffScans(baseline, resp)
+	fmt.Fprintf(w, "\nDelta check against %s: %d of %d baseline ports still open\n",
+		path, len(baseline.OpenPorts)-len(diff.Closed), len(baseline.OpenPorts))
+	for _, port := range diff.Closed {
+		fmt.Fprintf(w, "- %s (no longer open)\n", formatPortLine(port))
+	}
+	for _, port := range diff.Opened {
+		fmt.Fprintf(w, "+ %s (newly open, found in the sample)\n", formatPortLine(port))
+	}
+}
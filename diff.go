@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ScanDiff lists the ports whose state changed between two scans
+type ScanDiff struct {
+	Target string     `json:"target"`
+	Opened []PortInfo `json:"opened"`
+	Closed []PortInfo `json:"closed"`
+}
+
+// DiffScans compares the open ports of two scans. Ports are matched by port
+// number and protocol and, for dual-stack results, by address.
+func DiffScans(oldScan, newScan ScanResponse) ScanDiff {
+	type key struct {
+		ip       string
+		port     int
+		protocol string
+	}
+	keyOf := func(p PortInfo) key {
+		// Results saved before the protocol was recorded are TCP
+		if p.Protocol == "" {
+			return key{p.IP, p.Port, protoTCP}
+		}
+		return key{p.IP, p.Port, p.Protocol}
+	}
+	oldOpen := make(map[key]bool, len(oldScan.OpenPorts))
+	for _, p := range oldScan.OpenPorts {
+		oldOpen[keyOf(p)] = true
+	}
+	newOpen := make(map[key]bool, len(newScan.OpenPorts))
+	for _, p := range newScan.OpenPorts {
+		newOpen[keyOf(p)] = true
+	}
+
+	diff := ScanDiff{Target: newScan.Target, Opened: []PortInfo{}, Closed: []PortInfo{}}
+	for _, p := range newScan.OpenPorts {
+		if !oldOpen[keyOf(p)] {
+			diff.Opened = append(diff.Opened, p)
+		}
+	}
+	for _, p := range oldScan.OpenPorts {
+		if !newOpen[keyOf(p)] {
+			diff.Closed = append(diff.Closed, p)
+		}
+	}
+	sortPortInfos(diff.Opened)
+	sortPortInfos(diff.Closed)
+	return diff
+}
+
+// LoadScanResponse reads a scan result previously saved with -json or in
+// the binary format
+func LoadScanResponse(path string) (ScanResponse, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ScanResponse{}, err
+	}
+	if isBinaryResult(data) {
+		resp, err := DecodeBinary(bytes.NewReader(data))
+		if err != nil {
+			return ScanResponse{}, fmt.Errorf("%s: invalid binary scan result: %v", path, err)
+		}
+		return resp, nil
+	}
+	var resp ScanResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return ScanResponse{}, fmt.Errorf("%s: invalid scan result: %v", path, err)
+	}
+	return resp, nil
+}
+
+// patchContext is how many unchanged ports surround each change in a patch
+const patchContext = 3
+
+// patchLine is one line of a patch body: ' ' for an unchanged port, '-' for
+// one no longer open and '+' for one newly open
+type patchLine struct {
+	op   byte
+	text string
+}
+
+// FormatPatch renders the difference between two scans as a unified diff
+// of their open ports, one line per port, so scan drift can be reviewed in
+// code-review tooling. Ports are matched as DiffScans matches them and
+// listed in sorted order, so the same pair of scans always gives the same
+// bytes. Scans with the same open ports give an empty patch.
+func FormatPatch(oldScan, newScan ScanResponse, oldName, newName string) string {
+	oldPorts := slices.Clone(oldScan.OpenPorts)
+	newPorts := slices.Clone(newScan.OpenPorts)
+	sortPortInfos(oldPorts)
+	sortPortInfos(newPorts)
+
+	// Both lists are sorted the same way, so walking them side by side
+	// pairs up the ports open in both
+	compare := func(a, b PortInfo) int {
+		return cmp.Or(cmp.Compare(a.Port, b.Port), strings.Compare(a.IP, b.IP), strings.Compare(nmapProtocol(a), nmapProtocol(b)))
+	}
+	var lines []patchLine
+	i, j := 0, 0
+	for i < len(oldPorts) || j < len(newPorts) {
+		switch {
+		case j == len(newPorts) || (i < len(oldPorts) && compare(oldPorts[i], newPorts[j]) < 0):
+			lines = append(lines, patchLine{'-', patchPortLine(oldPorts[i])})
+			i++
+		case i == len(oldPorts) || compare(oldPorts[i], newPorts[j]) > 0:
+			lines = append(lines, patchLine{'+', patchPortLine(newPorts[j])})
+			j++
+		default:
+			lines = append(lines, patchLine{' ', patchPortLine(newPorts[j])})
+			i++
+			j++
+		}
+	}
+
+	var b strings.Builder
+	for start := 0; start < len(lines); {
+		first := slices.IndexFunc(lines[start:], func(l patchLine) bool { return l.op != ' ' })
+		if first < 0 {
+			break
+		}
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		// A hunk runs until more than twice the context separates two changes
+		first += start
+		last := first
+		for k := first + 1; k < len(lines) && k <= last+2*patchContext; k++ {
+			if lines[k].op != ' ' {
+				last = k
+			}
+		}
+		from, to := max(first-patchContext, 0), min(last+patchContext+1, len(lines))
+		writeHunk(&b, lines, from, to)
+		start = to
+	}
+	return b.String()
+}
+
+// writeHunk writes lines[from:to] as one hunk with its @@ header
+func writeHunk(b *strings.Builder, lines []patchLine, from, to int) {
+	oldStart, newStart := 1, 1
+	for _, l := range lines[:from] {
+		if l.op != '+' {
+			oldStart++
+		}
+		if l.op != '-' {
+			newStart++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, l := range lines[from:to] {
+		if l.op != '+' {
+			oldCount++
+		}
+		if l.op != '-' {
+			newCount++
+		}
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, l := range lines[from:to] {
+		b.WriteByte(l.op)
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+}
+
+// hunkRange formats a hunk's start and length as diff does: the length is
+// left out when it is one, and an empty range starts at the line before it
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return strconv.Itoa(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// patchPortLine is how a port appears in a patch, e.g. "443/tcp HTTPS on 10.0.0.5"
+func patchPortLine(port PortInfo) string {
+	line := fmt.Sprintf("%d/%s %s", port.Port, nmapProtocol(port), cmp.Or(port.Service, "unknown"))
+	if port.IP != "" {
+		line += " on " + port.IP
+	}
+	return line
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDNSPort is used when -dns names a server without a port
+const defaultDNSPort = "53"
+
+// defaultResolveTimeout bounds each target lookup unless -resolve-timeout
+// says otherwise
+const defaultResolveTimeout = 5 * time.Second
+
+// resolveTimeout is how long a single lookup may take before the scan gives
+// up on the name, so a bad resolver cannot stall the start of a scan
+var resolveTimeout = defaultResolveTimeout
+
+// resolver looks up every target name. It is the system resolver unless
+// UseDNSServer points it at a specific server.
+var resolver = net.DefaultResolver
+
+// ParseDNSServer checks a DNS server address such as 10.0.0.53:53 or
+// [2001:db8::53]:53 and returns it with the port filled in. The server must
+// be an IP address, since resolving its name would need a DNS server.
+func ParseDNSServer(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, port = addr, defaultDNSPort
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q: expected an IP address with an optional port", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid DNS server %q: port must be between 1 and 65535", addr)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// UseDNSServer sends every lookup to the given DNS server instead of the
+// ones configured on this machine. /etc/hosts is still consulted first.
+func UseDNSServer(addr string) error {
+	server, err := ParseDNSServer(addr)
+	if err != nil {
+		return err
+	}
+	resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+	return nil
+}
+
+// lookupHost resolves a target name with the configured resolver, giving
+// up after resolveTimeout with a clear error. Localhost names are answered
+// with the loopback addresses without a lookup.
+func lookupHost(ctx context.Context, host string) ([]string, error) {
+	if isLocalhost(host) {
+		return []string{"127.0.0.1", "::1"}, nil
+	}
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupHost(lookupCtx, host)
+	if err != nil {
+		return nil, resolveError(ctx, lookupCtx, err)
+	}
+	return addrs, nil
+}
+
+// lookupSRV fetches an SRV record, giving up after resolveTimeout
+func lookupSRV(ctx context.Context, name string) ([]*net.SRV, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	_, records, err := resolver.LookupSRV(lookupCtx, "", "", name)
+	if err != nil {
+		return nil, resolveError(ctx, lookupCtx, err)
+	}
+	return records, nil
+}
+
+// resolveError replaces the error of a lookup that ran out of time, but
+// was not cancelled by its caller, with one that says so
+func resolveError(ctx, lookupCtx context.Context, err error) error {
+	var dnsErr *net.DNSError
+	timedOut := lookupCtx.Err() != nil || (errors.As(err, &dnsErr) && dnsErr.IsTimeout)
+	if timedOut && ctx.Err() == nil {
+		return fmt.Errorf("DNS resolution timed out after %v", resolveTimeout)
+	}
+	return err
+}
+
+// wildcardCheckTimeout bounds the extra lookup made by DetectWildcardDNS
+const wildcardCheckTimeout = 2 * time.Second
+
+// DetectWildcardDNS reports whether a random name that should not exist in
+// the host's domain resolves to one of the host's addresses. That happens
+// with wildcard records and with networks that answer every query with a
+// sinkhole, where scanning the host says nothing about the intended
+// service. The domain is the host without its first label, or the host
+// itself when it has only two labels; single-label names are not checked.
+func DetectWildcardDNS(ctx context.Context, host string, addrs []string) bool {
+	host = strings.TrimSuffix(host, ".")
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 || isLocalhost(host) {
+		return false
+	}
+	domain := host
+	if len(labels) > 2 {
+		domain = strings.Join(labels[1:], ".")
+	}
+	random := make([]byte, 8)
+	rand.Read(random)
+	probe := "wildcard-check-" + hex.EncodeToString(random) + "." + domain
+
+	ctx, cancel := context.WithTimeout(ctx, wildcardCheckTimeout)
+	defer cancel()
+	probeAddrs, err := resolver.LookupHost(ctx, probe)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(probeAddrs, func(addr string) bool {
+		return slices.Contains(addrs, addr)
+	})
+}
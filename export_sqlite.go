@@ -0,0 +1,110 @@
+//go:build sqlite
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the tables on first use. Each run adds one row to
+// scans and one row per open port to ports.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS scans (
+	id               INTEGER PRIMARY KEY AUTOINCREMENT,
+	target           TEXT    NOT NULL,
+	timestamp        TEXT    NOT NULL,
+	start_port       INTEGER NOT NULL,
+	end_port         INTEGER NOT NULL,
+	total_ports      INTEGER NOT NULL,
+	open_ports       INTEGER NOT NULL,
+	closed_ports     INTEGER NOT NULL,
+	duration_seconds REAL    NOT NULL,
+	error            TEXT,
+	version          TEXT
+);
+CREATE TABLE IF NOT EXISTS ports (
+	scan_id     INTEGER NOT NULL REFERENCES scans(id),
+	ip          TEXT,
+	port        INTEGER NOT NULL,
+	protocol    TEXT    NOT NULL,
+	service     TEXT,
+	state       TEXT    NOT NULL,
+	connect_ms  REAL,
+	http_status INTEGER,
+	tls         INTEGER NOT NULL,
+	banner      TEXT,
+	banner_hash TEXT
+);
+CREATE INDEX IF NOT EXISTS ports_scan_id ON ports(scan_id);
+CREATE INDEX IF NOT EXISTS scans_target ON scans(target, timestamp);
+`
+
+func init() {
+	exportSQLite = writeSQLite
+}
+
+// writeSQLite appends the scan in a single transaction so an interrupted
+// export never leaves a scan without its ports
+func writeSQLite(path string, resp ScanResponse) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create tables in %s: %v", path, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO scans (target, timestamp, start_port, end_port, total_ports,
+		open_ports, closed_ports, duration_seconds, error, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		resp.Target, resp.Timestamp.UTC().Format(time.RFC3339Nano), resp.StartPort, resp.EndPort,
+		resp.TotalPorts, len(resp.OpenPorts), resp.ClosedPorts, resp.DurationSeconds,
+		nullString(resp.Error), resp.Meta.Version)
+	if err != nil {
+		return fmt.Errorf("failed to insert scan: %v", err)
+	}
+	scanID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO ports (scan_id, ip, port, protocol, service, state,
+		connect_ms, http_status, tls, banner, banner_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, port := range resp.OpenPorts {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = protoTCP
+		}
+		var httpStatus sql.NullInt64
+		if port.HTTPStatus != 0 {
+			httpStatus = sql.NullInt64{Int64: int64(port.HTTPStatus), Valid: true}
+		}
+		_, err := stmt.Exec(scanID, nullString(port.IP), port.Port, protocol, nullString(port.Service),
+			port.State, port.ConnectMs, httpStatus, port.TLS, nullString(port.Banner), nullString(port.BannerHash))
+		if err != nil {
+			return fmt.Errorf("failed to insert port %d: %v", port.Port, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// nullString stores empty strings as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
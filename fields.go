@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// responseFields and portFields are the JSON names of ScanResponse and
+// PortInfo in declaration order, which projected output keeps
+var (
+	responseFields = jsonFieldNames(reflect.TypeFor[ScanResponse]())
+	portFields     = jsonFieldNames(reflect.TypeFor[PortInfo]())
+)
+
+// portListFields are the ScanResponse fields that hold PortInfo lists
+var portListFields = []string{"open_ports", "closed_sample"}
+
+// FieldSet is the selection given with -fields. A name can pick a field of
+// the result (target, status) or of each port (port, service, state).
+type FieldSet map[string]bool
+
+// jsonFieldNames lists the JSON names of a struct's exported fields
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// ParseFields checks a comma-separated field list against the known
+// result and port fields
+func ParseFields(spec string) (FieldSet, error) {
+	fields := make(FieldSet)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(responseFields, name) && !slices.Contains(portFields, name) {
+			return nil, fmt.Errorf("unknown field %q (result fields: %s; port fields: %s)",
+				name, strings.Join(responseFields, ","), strings.Join(portFields, ","))
+		}
+		fields[name] = true
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields given")
+	}
+	return fields, nil
+}
+
+// selectsPortFields reports whether any per-port field is selected
+func (f FieldSet) selectsPortFields() bool {
+	return slices.ContainsFunc(portFields, func(name string) bool { return f[name] })
+}
+
+// ProjectResponse keeps only the selected fields of a result. When port
+// fields are selected the port lists are kept, each port cut down to them.
+func ProjectResponse(resp ScanResponse, fields FieldSet) (json.Marshaler, error) {
+	object, err := toRawObject(resp)
+	if err != nil {
+		return nil, err
+	}
+	keep := func(name string) bool {
+		return fields[name] || (slices.Contains(portListFields, name) && fields.selectsPortFields())
+	}
+	projected := projectObject(object, responseFields, keep)
+	for _, name := range portListFields {
+		raw, ok := projected.values[name]
+		if !ok || bytes.Equal(raw, []byte("null")) {
+			continue
+		}
+		var ports []PortInfo
+		if err := json.Unmarshal(raw, &ports); err != nil {
+			return nil, err
+		}
+		list, err := ProjectPorts(ports, fields)
+		if err != nil {
+			return nil, err
+		}
+		if projected.values[name], err = json.Marshal(list); err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+// ProjectPorts cuts each port down to the selected port fields
+func ProjectPorts(ports []PortInfo, fields FieldSet) ([]json.Marshaler, error) {
+	list := make([]json.Marshaler, 0, len(ports))
+	for _, port := range ports {
+		object, err := toRawObject(port)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, projectObject(object, portFields, func(name string) bool { return fields[name] }))
+	}
+	return list, nil
+}
+
+// toRawObject marshals a value and splits the resulting object into fields
+func toRawObject(v any) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(data, &object)
+	return object, err
+}
+
+// projectedObject is a JSON object whose keys are written in a fixed order
+type projectedObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// projectObject keeps the fields of an object that keep selects, in the
+// given order. Fields left out by omitempty stay absent.
+func projectObject(object map[string]json.RawMessage, order []string, keep func(string) bool) projectedObject {
+	projected := projectedObject{values: make(map[string]json.RawMessage)}
+	for _, name := range order {
+		if value, ok := object[name]; ok && keep(name) {
+			projected.keys = append(projected.keys, name)
+			projected.values[name] = value
+		}
+	}
+	return projected
+}
+
+// MarshalJSON implements json.Marshaler
+func (o projectedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
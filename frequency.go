@@ -0,0 +1,139 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed port-frequency.csv
+var defaultFrequencyCSV string
+
+// portRanking lists ports from most to least commonly open
+var portRanking []int
+
+// portFrequency maps each ranked port to its frequency
+var portFrequency map[int]float64
+
+func init() {
+	ranking, freq, _, err := parseFrequencyCSV(defaultFrequencyCSV, "port-frequency.csv")
+	if err != nil {
+		panic(err)
+	}
+	portRanking, portFrequency = ranking, freq
+}
+
+// LoadFrequencyFile replaces the embedded ranking with one read from a
+// port,frequency CSV file. Duplicate ports keep their first entry and are
+// returned as warnings.
+func LoadFrequencyFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ranking, freq, warnings, err := parseFrequencyCSV(string(data), path)
+	if err != nil {
+		return nil, err
+	}
+	if len(ranking) == 0 {
+		return nil, fmt.Errorf("%s: no ports found", path)
+	}
+	portRanking, portFrequency = ranking, freq
+	return warnings, nil
+}
+
+// parseFrequencyCSV parses port,frequency lines, skipping blanks and # comments
+func parseFrequencyCSV(data, name string) ([]int, map[int]float64, []string, error) {
+	freq := make(map[int]float64)
+	var warnings []string
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, nil, nil, fmt.Errorf("%s:%d: expected port,frequency", name, i+1)
+		}
+		port, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil || port < 1 || port > 65535 {
+			return nil, nil, nil, fmt.Errorf("%s:%d: invalid port %q", name, i+1, fields[0])
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil || f < 0 {
+			return nil, nil, nil, fmt.Errorf("%s:%d: invalid frequency %q", name, i+1, fields[1])
+		}
+		if _, dup := freq[port]; dup {
+			warnings = append(warnings, fmt.Sprintf("%s:%d: duplicate port %d ignored", name, i+1, port))
+			continue
+		}
+		freq[port] = f
+	}
+
+	ranking := make([]int, 0, len(freq))
+	for port := range freq {
+		ranking = append(ranking, port)
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if freq[ranking[i]] != freq[ranking[j]] {
+			return freq[ranking[i]] > freq[ranking[j]]
+		}
+		return ranking[i] < ranking[j]
+	})
+	return ranking, freq, warnings, nil
+}
+
+// TopPortList returns the n most commonly open ports. If n exceeds the size
+// of the ranking, every ranked port is returned.
+func TopPortList(n int) []int {
+	if n > len(portRanking) {
+		n = len(portRanking)
+	}
+	return append([]int(nil), portRanking[:n]...)
+}
+
+// OrderByFrequency returns the ports with the most commonly open first.
+// Ports missing from the ranking follow in ascending order.
+func OrderByFrequency(ports []int) []int {
+	ordered := append([]int(nil), ports...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		fi, iRanked := portFrequency[ordered[i]]
+		fj, jRanked := portFrequency[ordered[j]]
+		if iRanked != jRanked {
+			return iRanked
+		}
+		if fi != fj {
+			return fi > fj
+		}
+		return ordered[i] < ordered[j]
+	})
+	return ordered
+}
+
+// The -ephemeral preset covers the dynamic port range, where open ports are
+// rare and usually belong to short-lived services
+const (
+	ephemeralStart      = 49152
+	ephemeralEnd        = 65535
+	ephemeralSampleRate = 0.25
+	ephemeralTimeoutMs  = 1000
+)
+
+// EphemeralSample returns every ranked port in the ephemeral range, most
+// common first, followed by a random sample of the remaining ports in
+// ascending order. rate is the fraction of unranked ports kept.
+func EphemeralSample(rate float64) []int {
+	var ranked, sampled []int
+	for p := ephemeralStart; p <= ephemeralEnd; p++ {
+		if _, ok := portFrequency[p]; ok {
+			ranked = append(ranked, p)
+		} else if rand.Float64() < rate {
+			sampled = append(sampled, p)
+		}
+	}
+	return append(OrderByFrequency(ranked), sampled...)
+}
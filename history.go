@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Default history bounds for the web server
+const (
+	defaultHistoryMaxEntries = 1000
+	defaultHistoryMaxBytes   = 64 << 20
+)
+
+// HistoryEntry is a completed scan kept by the web server
+type HistoryEntry struct {
+	ScheduleID string       `json:"schedule_id,omitempty"`
+	Response   ScanResponse `json:"response"`
+}
+
+// scanHistory keeps completed scan results in memory, evicting the oldest
+// once either the entry count or the approximate size exceeds its limit.
+// A zero limit means unbounded.
+type scanHistory struct {
+	mu         sync.Mutex
+	entries    []HistoryEntry
+	sizes      []int
+	bytes      int
+	evictions  int
+	maxEntries int
+	maxBytes   int
+}
+
+// newScanHistory creates a history with the given bounds
+func newScanHistory(maxEntries, maxBytes int) *scanHistory {
+	return &scanHistory{maxEntries: maxEntries, maxBytes: maxBytes}
+}
+
+// Add records a completed scan. The size of an entry is taken to be the
+// length of its JSON encoding, which tracks its memory use closely enough.
+func (h *scanHistory) Add(entry HistoryEntry) {
+	data, _ := json.Marshal(entry)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	h.sizes = append(h.sizes, len(data))
+	h.bytes += len(data)
+	for len(h.entries) > 0 && h.overLimit() {
+		h.bytes -= h.sizes[0]
+		h.entries = h.entries[1:]
+		h.sizes = h.sizes[1:]
+		h.evictions++
+	}
+}
+
+// overLimit reports whether either bound is exceeded
+func (h *scanHistory) overLimit() bool {
+	return (h.maxEntries > 0 && len(h.entries) > h.maxEntries) ||
+		(h.maxBytes > 0 && h.bytes > h.maxBytes)
+}
+
+// List returns a copy of the recorded scans, oldest first
+func (h *scanHistory) List() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]HistoryEntry(nil), h.entries...)
+}
+
+// Usage reports the number of entries, their approximate size in bytes and
+// how many entries have been evicted
+func (h *scanHistory) Usage() (entries, bytes, evictions int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.entries), h.bytes, h.evictions
+}
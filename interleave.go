@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// portTurns makes the hosts of an interleaved scan take turns dispatching
+// dials, one port each, so every host gets its first port before any host
+// gets its second. A host that has nothing left to dispatch leaves the
+// rotation so the others never wait on it.
+type portTurns struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	next     int
+	finished []bool
+}
+
+// newPortTurns creates the rotation for the given number of hosts
+func newPortTurns(hosts int) *portTurns {
+	t := &portTurns{finished: make([]bool, hosts)}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// host returns the handle one host uses to take its turns
+func (t *portTurns) host(i int) *hostTurn {
+	return &hostTurn{turns: t, index: i}
+}
+
+// advance hands the turn to the next host still in the rotation. The caller
+// holds the lock.
+func (t *portTurns) advance() {
+	for range t.finished {
+		t.next = (t.next + 1) % len(t.finished)
+		if !t.finished[t.next] {
+			break
+		}
+	}
+	t.cond.Broadcast()
+}
+
+// hostTurn is one host's place in a portTurns rotation. A nil hostTurn is a
+// scan that is not interleaved, for which every method is a no-op.
+type hostTurn struct {
+	turns *portTurns
+	index int
+}
+
+// wait blocks until it is this host's turn or ctx is done
+func (h *hostTurn) wait(ctx context.Context) error {
+	if h == nil {
+		return nil
+	}
+	t := h.turns
+	stop := context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		t.cond.Broadcast()
+		t.mu.Unlock()
+	})
+	defer stop()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.next != h.index && !t.finished[h.index] && ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	return ctx.Err()
+}
+
+// pass hands the turn on after this host has dispatched a port
+func (h *hostTurn) pass() {
+	if h == nil {
+		return
+	}
+	t := h.turns
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next == h.index {
+		t.advance()
+	}
+}
+
+// finish takes this host out of the rotation. It is safe to call more than
+// once.
+func (h *hostTurn) finish() {
+	if h == nil {
+		return
+	}
+	t := h.turns
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.finished[h.index] {
+		return
+	}
+	t.finished[h.index] = true
+	if t.next == h.index {
+		t.advance()
+	}
+}
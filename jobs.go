@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQueueSize bounds the scan jobs waiting for a worker
+const defaultQueueSize = 100
+
+// maxFinishedJobs is how many finished jobs are kept for polling before the
+// oldest are forgotten
+const maxFinishedJobs = 1000
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobCancelled = "cancelled"
+)
+
+// Job is a scan accepted by the web server and run by the next free worker
+type Job struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"`
+	Position  int           `json:"position,omitempty"`
+	Submitted time.Time     `json:"submitted"`
+	Started   *time.Time    `json:"started,omitempty"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+	Result    *ScanResponse `json:"result,omitempty"`
+
+	req    ScanRequest
+	key    string
+	ctx    context.Context
+	cancel context.CancelFunc
+	gate   *pauseGate
+}
+
+// jobQueue runs scan jobs on a fixed number of workers. Jobs wait in the
+// order they were submitted; once maxQueued are waiting, new ones are
+// refused. A nil jobQueue means scans run as their requests arrive.
+type jobQueue struct {
+	mu        sync.Mutex
+	wake      *sync.Cond
+	ctx       context.Context
+	stopAll   context.CancelFunc
+	maxQueued int
+	busy      int
+	jobs      map[string]*Job
+	waiting   []*Job
+	finished  []string
+	running   *runningScans
+	history   *scanHistory
+	cache     *resultCache
+}
+
+// newJobQueue starts the given number of workers, or returns nil when
+// workers is 0
+func newJobQueue(workers, maxQueued int, running *runningScans, history *scanHistory, cache *resultCache) *jobQueue {
+	if workers <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &jobQueue{
+		ctx:       ctx,
+		stopAll:   cancel,
+		maxQueued: maxQueued,
+		jobs:      make(map[string]*Job),
+		running:   running,
+		history:   history,
+		cache:     cache,
+	}
+	q.wake = sync.NewCond(&q.mu)
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues a validated scan, returning false when the queue is full.
+// The job shares its ID with the running scan, so it can be paused through
+// /scan/{id}/pause while it waits or runs.
+func (q *jobQueue) Submit(req ScanRequest, key string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiting) >= q.maxQueued || q.ctx.Err() != nil {
+		return Job{}, false
+	}
+	id, gate := q.running.Add()
+	ctx, cancel := context.WithCancel(q.ctx)
+	job := &Job{
+		ID:        id,
+		Status:    JobQueued,
+		Submitted: time.Now(),
+		req:       req,
+		key:       key,
+		ctx:       ctx,
+		cancel:    cancel,
+		gate:      gate,
+	}
+	q.jobs[id] = job
+	q.waiting = append(q.waiting, job)
+	q.wake.Signal()
+	return q.snapshot(job), true
+}
+
+// work takes the oldest waiting job and scans until the queue is stopped
+func (q *jobQueue) work() {
+	for {
+		q.mu.Lock()
+		for len(q.waiting) == 0 && q.ctx.Err() == nil {
+			q.wake.Wait()
+		}
+		if q.ctx.Err() != nil {
+			q.mu.Unlock()
+			return
+		}
+		job := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		now := time.Now()
+		job.Status = JobRunning
+		job.Started = &now
+		q.busy++
+		q.mu.Unlock()
+
+		response := RunScanContext(job.ctx, job.req, false, ScanCallbacks{Pause: job.gate})
+		q.history.Add(HistoryEntry{Response: response})
+		q.cache.Put(job.key, response)
+
+		q.mu.Lock()
+		q.busy--
+		q.finish(job, &response)
+		q.mu.Unlock()
+	}
+}
+
+// finish records a job's result and forgets the oldest finished jobs. The
+// caller holds q.mu.
+func (q *jobQueue) finish(job *Job, response *ScanResponse) {
+	q.running.Remove(job.ID)
+	job.cancel()
+	now := time.Now()
+	job.Finished = &now
+	job.Result = response
+	job.Status = JobDone
+	if response == nil || response.Status == StatusCancelled {
+		job.Status = JobCancelled
+	}
+	q.finished = append(q.finished, job.ID)
+	for len(q.finished) > maxFinishedJobs {
+		delete(q.jobs, q.finished[0])
+		q.finished = q.finished[1:]
+	}
+}
+
+// Get returns a job by ID
+func (q *jobQueue) Get(id string) (Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return q.snapshot(job), true
+}
+
+// Cancel stops a running job or takes a queued one off the queue,
+// reporting whether the job exists
+func (q *jobQueue) Cancel(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return false
+	}
+	if i := slices.Index(q.waiting, job); i >= 0 {
+		q.waiting = slices.Delete(q.waiting, i, i+1)
+		q.finish(job, nil)
+	}
+	job.cancel()
+	return true
+}
+
+// Usage reports the jobs waiting for a worker and the workers busy
+func (q *jobQueue) Usage() (queued, busy int) {
+	if q == nil {
+		return 0, 0
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiting), q.busy
+}
+
+// Stop cancels every running job and stops the workers. Jobs still
+// waiting are never started.
+func (q *jobQueue) Stop() {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopAll()
+	q.wake.Broadcast()
+}
+
+// snapshot copies a job for a response, filling in its queue position.
+// The caller holds q.mu.
+func (q *jobQueue) snapshot(job *Job) Job {
+	copied := *job
+	if job.Status == JobQueued {
+		copied.Position = slices.Index(q.waiting, job) + 1
+	}
+	return copied
+}
+
+// handleJob serves GET /jobs/{id} and DELETE /jobs/{id}
+func (q *jobQueue) handleJob(w http.ResponseWriter, r *http.Request) {
+	if q == nil {
+		http.Error(w, "The job queue is not enabled; start the server with -scan-workers", http.StatusNotFound)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	switch r.Method {
+	case "GET":
+	case "DELETE":
+		if !q.Cancel(id) {
+			http.Error(w, "Job not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, ok := q.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// openFileLimit reports no limit on platforms without RLIMIT_NOFILE
+func openFileLimit() int {
+	return 0
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// openFileLimit returns the soft limit on open file descriptors, or 0 if
+// it cannot be read
+func openFileLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0
+	}
+	return int(min(uint64(rl.Cur), maxConcurrencyLimit+fdReserve))
+}
@@ -1,50 +1,415 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
+// exitCheckFailed is returned when a scan completes but a policy check fails
+const exitCheckFailed = 3
+
 func main() {
-	// Command line flags
-	webMode := flag.Bool("web", false, "Run in web interface mode")
-	host := flag.String("host", "", "Target host to scan")
-	startPort := flag.Int("start", 1, "Starting port")
-	endPort := flag.Int("end", 1024, "Ending port")
-	maxConcurrent := flag.Int("concurrent", 100, "Maximum concurrent connections")
-	timeoutMs := flag.Int("timeout", 500, "Connection timeout in milliseconds")
-	jsonOutput := flag.Bool("json", false, "Output in JSON format")
-	quiet := flag.Bool("quiet", false, "Suppress progress output")
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "scan":
+			runScan(os.Args[2:], false)
+			return
+		case "web":
+			runWeb(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "merge":
+			runMerge(os.Args[2:])
+			return
+		case "verify-sig":
+			runVerifySig(os.Args[2:])
+			return
+		case "version":
+			runVersion(os.Args[2:])
+			return
+		case "help":
+			printUsage()
+			return
+		}
+	}
+
+	// No subcommand: accept the flat flag set used by earlier releases
+	runScan(os.Args[1:], true)
+}
+
+// printUsage lists the available subcommands
+func printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  port-scanner scan -host example.com -start 1 -end 1000  # Scan a host")
+	fmt.Println("  port-scanner scan example.com                           # Quick scan")
+	fmt.Println("  port-scanner web -listen :8080                          # Start web interface")
+	fmt.Println("  port-scanner diff old.json new.json                     # Compare two JSON results")
+	fmt.Println("  port-scanner merge shard1.json shard2.json              # Combine results for one target")
+	fmt.Println("  port-scanner verify-sig -key k.key result.json          # Check a signed result")
+	fmt.Println("  port-scanner version                                    # Show version")
+	fmt.Println()
+	fmt.Println("Run 'port-scanner <command> -h' for the options of each command.")
+}
+
+// runScan implements the scan command. In legacy mode the old top-level
+// -web flag is also accepted and a deprecation notice is printed.
+func runScan(args []string, legacy bool) {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	var webMode *bool
+	if legacy {
+		fs.Usage = func() {
+			printUsage()
+			fmt.Println()
+			fmt.Println("Scan options:")
+			fs.PrintDefaults()
+		}
+		webMode = fs.Bool("web", false, "Run in web interface mode (deprecated: use 'port-scanner web')")
+	}
+	showVersion := fs.Bool("version", false, "Show version information and exit")
+	host := fs.String("host", "", "Target host to scan")
+	srv := fs.String("srv", "", "Scan the target:port endpoints of a DNS SRV record (e.g. _sip._tcp.example.com) instead of -host")
+	startPort := fs.Int("start", 1, "Starting port")
+	endPort := fs.Int("end", 1024, "Ending port")
+	portSpec := fs.String("p", "", "Ports to scan, e.g. 22,80,8000-8100 or \"T:22,80 U:53,161\" for TCP and UDP")
+	topPorts := fs.Int("top-ports", 0, "Scan the N most commonly open ports instead of a range")
+	ephemeral := fs.Bool("ephemeral", false, "Sample the ephemeral range 49152-65535 with a longer timeout")
+	portsFile := fs.String("ports-file", "", "File of ports to scan, one per line (# comments allowed)")
+	knownOnly := fs.Bool("known-only", false, "Scan only the well-known service ports within the selected ports")
+	alsoPorts := fs.String("also", "", "Extra TCP ports to scan on top of the selection, e.g. 8000,9000")
+	shard := fs.String("shard", "", "Scan only shard i of n of the ports, e.g. 2/5, to split a scan across machines")
+	excludePorts := fs.String("exclude", "", "TCP ports never to scan, applied after -known-only and -also")
+	freqFile := fs.String("freq-file", "", "CSV of port,frequency used to rank ports for -top-ports")
+	concurrent := fs.String("concurrent", "100", "Maximum concurrent connections per host: a number, Nx for N per CPU, or auto (or 0)")
+	hostConcurrency := fs.Int("host-concurrent", 1, "Number of hosts scanned in parallel when -host lists several")
+	interleave := fs.Bool("interleave", false, "Scan several hosts port by port (each port on every host before the next) to spread the load")
+	timeoutMs := fs.Int("timeout", 500, "Connection timeout in milliseconds")
+	timeoutDur := fs.Duration("timeout-dur", 0, "Connection timeout as a duration (e.g. 750ms, 2s); overrides -timeout")
+	tcpConcurrent := fs.Int("tcp-concurrent", 0, "Concurrent TCP connections per host; UDP ports then scan alongside in their own pool (0 uses -concurrent)")
+	udpConcurrent := fs.Int("udp-concurrent", 0, "Concurrent UDP probes per host; TCP ports then scan alongside in their own pool (0 uses -concurrent)")
+	tcpTimeout := fs.Int("tcp-timeout", 0, "Connection timeout in milliseconds for TCP ports (0 uses -timeout)")
+	udpTimeout := fs.Int("udp-timeout", 0, "Reply timeout in milliseconds for UDP ports (0 uses -timeout)")
+	timeoutOverrides := timeoutOverrideFlag{}
+	fs.Var(timeoutOverrides, "timeout-override", "Per-port timeouts in milliseconds that replace -timeout for those ports, e.g. 3306:2000,5432:2000")
+	jsonOutput := fs.Bool("json", false, "Output in JSON format")
+	insights := fs.Bool("insights", false, "Print security notes for open ports with a known risk (e.g. Telnet)")
+	groupByCategory := fs.Bool("group-by-category", false, "Group open ports by service category (web, database, remote-access, mail, other)")
+	byService := fs.Bool("by-service", false, "Summarize the open ports as counts per service, e.g. 3× HTTP, 2× SSH")
+	jsonPortsOnly := fs.Bool("json-ports-only", false, "Output only the open_ports array as JSON")
+	fieldList := fs.String("fields", "", "Keep only these result and port fields in -json output, e.g. target,port,service,state")
+	markdown := fs.Bool("markdown", false, "Output a Markdown table of open ports")
+	stixOutput := fs.Bool("stix", false, "Output the open ports as a STIX 2.1 bundle of observed-data for threat-intel platforms")
+	portList := fs.Bool("list", false, "Output only the open port numbers, sorted, one per line")
+	quiet := fs.Bool("quiet", false, "Suppress progress output")
+	progressEvery := fs.Int("progress-every", 0, "Ports between progress updates (0 = auto)")
+	jitterMs := fs.Int("jitter", 0, "Random delay of up to this many milliseconds before each connection")
+	jitterDur := fs.Duration("jitter-dur", 0, "Maximum random delay before each connection as a duration; overrides -jitter")
+	filteredTimeout := fs.Duration("filtered-timeout", 0, "Shorter dial timeout once the host has answered, e.g. 100ms (0 = off)")
+	var proxies proxyListFlag
+	fs.Var(&proxies, "proxy", "Connect through this SOCKS5 proxy, e.g. socks5://bastion:1080; repeat to chain proxies in order")
+	sourcePorts := fs.String("source-ports", "", "Bind each connection to a local port from this range, e.g. 40000-41000")
+	synScan := fs.Bool("syn", false, "Half-open SYN scan using raw sockets (Linux, root or CAP_NET_RAW)")
+	verify := fs.Bool("verify", false, "Re-scan closed ports once with a longer timeout")
+	allowlistFile := fs.String("allowlist", "", "File of ports allowed to be open; other open ports are violations")
+	dedupe := fs.Bool("dedupe", false, "Collapse identical open ports on several addresses of one host, listing the addresses")
+	dualStack := fs.Bool("dual-stack", false, "Scan every IPv4 and IPv6 address the host resolves to")
+	slowStart := fs.Bool("slow-start", false, "Start with a few connections and ramp up to -concurrent while timeouts stay steady")
+	tcpInfo := fs.Bool("tcp-info", false, "Record the MSS, peer window and TCP options negotiated with each open port (Linux; not with -syn)")
+	targetFriendly := fs.Bool("target-friendly", false, "Space out new connections (about 500/s per host) so small devices' accept queues keep up")
+	retries := fs.Int("retries", 0, "Re-dial ports that timed out up to N times after the main pass")
+	retryBackoff := fs.String("retry-backoff", defaultBackoffStrategy, "How the pause before each retry round grows: constant, linear or exponential")
+	retryBackoffBase := fs.Duration("retry-backoff-base", defaultBackoffBase, "Pause before the first retry round")
+	retryBackoffMax := fs.Duration("retry-backoff-max", defaultBackoffMax, "Longest pause between retry rounds")
+	deadHost := fs.Int("dead-host-threshold", 0, "Stop scanning a host after this many consecutive connection timeouts (0 = off)")
+	failFast := fs.Bool("fail-fast", false, "Abort on the first systemic dial error (e.g. network unreachable)")
+	grabBanner := fs.Bool("banner", false, "Read the greeting each open port sends and record it with a stable hash")
+	noWildcardCheck := fs.Bool("no-wildcard-check", false, "Skip the extra DNS lookup that detects wildcard DNS and sinkholes")
+	idempotencyKey := fs.String("idempotency-key", "", "Key echoed in the result and sent with -webhook so receivers can drop repeated deliveries (default: a hash of the request)")
+	detectResets := fs.Bool("detect-resets", false, "Flag open ports that close or reset a new connection at once without sending data (tarpit/honeypot heuristic)")
+	measureTTFB := fs.Bool("ttfb", false, "Measure how long each open port takes to send its first byte unprompted (-1 if it waits)")
+	expectBanner := expectBannerFlag{}
+	fs.Var(expectBanner, "expect-banner", "Fail unless the port's banner matches a regex, as port:regex (implies -banner); repeatable")
+	httpProbe := fs.Bool("http-probe", false, "Send an HTTP HEAD request to each open port")
+	followRedirects := fs.Bool("follow-redirects", false, "Let the HTTP probe follow one redirect hop")
+	httpPath := fs.String("http-path", "", "Path the HTTP probe requests, e.g. /healthz (default /; implies -http-probe)")
+	httpMethod := fs.String("http-method", "", "Method the HTTP probe sends: HEAD, GET or OPTIONS (default HEAD; implies -http-probe)")
+	httpHeaders := httpHeaderFlag{}
+	fs.Var(httpHeaders, "http-header", "Header the HTTP probe sends, as \"Name: value\" (implies -http-probe); repeatable")
+	var outputs outputFileList
+	fs.Var(&outputs, "out", "Also write results to a file as format:path (text, json, csv, xml, md, nmap, gnmap, stix or bin); repeatable")
+	partialOnError := fs.Bool("partial-on-error", false, "Write the results of a scan that failed or was interrupted to <path>.partial, leaving -out files untouched")
+	outputAll := fs.String("oA", "", "Write <basename>.nmap, <basename>.gnmap and <basename>.xml, like nmap's -oA")
+	ndjsonPath := fs.String("ndjson-file", "", "Append each open port to this file as a JSON line as soon as it is found")
+	sqlitePath := fs.String("sqlite", "", "Append each scan and its open ports to this SQLite database (needs -tags sqlite)")
+	signKeyFile := fs.String("sign-key", "", "Sign each -out file with the HMAC key in this file, writing <path>.sig")
+	webhookURL := fs.String("webhook", "", "POST the JSON result to this URL when the scan finishes (failures are logged, not fatal)")
+	webhookKeyFile := fs.String("webhook-key", "", "Sign -webhook requests with the HMAC key in this file (X-Scan-Signature header)")
+	tlsProbe := fs.Bool("tls-probe", false, "Attempt a TLS handshake on each open port")
+	quicProbe := fs.Bool("quic-probe", false, "Attempt a QUIC handshake on the UDP port matching each open port (needs -tags quic)")
+	vhost := fs.String("vhost", "", "Virtual host to probe when dialing by IP: sent as the TLS server name and HTTP Host header")
+	tlsSNI := fs.String("tls-sni", "", "Server name to send during TLS handshakes (implies -tls-probe)")
+	tlsALPN := fs.String("tls-alpn", "", "Comma-separated ALPN protocols to offer, e.g. h2,http/1.1 (implies -tls-probe)")
+	certExpiryWarn := fs.Int("cert-expiry-warn", 0, "Flag TLS certificates expiring within this many days and exit with status 3 (implies -tls-probe)")
+	closedSample := fs.Int("closed-sample", 0, "Include up to N closed ports in the results as evidence of coverage")
+	publishURL := fs.String("publish", "", "Publish each open port to a message queue (e.g. nats://localhost:4222/subject)")
+	maxDials := fs.Int("max-dials", 0, "Maximum total connections for the scan (0 = unlimited)")
+	sortBy := fs.String("sort-by", SortPort, "Order of the open ports: port, discovery (order found), service or connect-time")
+	maxDialsMode := fs.String("max-dials-mode", "strict", "What to do when -max-dials is exceeded: strict (refuse) or truncate")
+	streamOnly := fs.Bool("stream", false, "Print open ports as they are found without collecting them")
+	templateText := fs.String("template", "", "Render results with a Go text/template")
+	templateFile := fs.String("template-file", "", "Render results with a Go text/template read from a file")
+	watchFile := fs.String("watch", "", "Re-scan every -interval and print changes against this baseline JSON file, updating it")
+	watchInterval := fs.Duration("interval", time.Minute, "Time between scans in -watch mode")
+	deltaFrom := fs.String("delta-from", "", "Re-check only the ports open in this baseline JSON file plus a random sample of the rest (a missing file is created by a full scan)")
+	deltaSample := fs.Int("delta-sample", defaultDeltaSample, "Ports closed in the -delta-from baseline to re-check at random")
+	transitions := fs.Bool("transitions", false, "In -watch mode, print each port state change as a JSON line (port, from, to, timestamp)")
+	bench := fs.Bool("bench", false, "Measure the maximum scan rate against local listeners at increasing concurrency and exit")
+	includeCmdline := fs.Bool("include-cmdline", false, "Record the command line (secrets redacted) in the result's meta.command_line")
+	anonymize := fs.Bool("anonymize", false, "Replace hostnames and addresses in the output with stable per-run pseudonyms")
+	dnsServer := fs.String("dns", "", "Resolve targets with this DNS server (e.g. 10.0.0.53:53) instead of the system resolver")
+	fs.DurationVar(&resolveTimeout, "resolve-timeout", defaultResolveTimeout, "Give up resolving a target name after this long")
+	skipEdges := fs.Bool("skip-network-broadcast", false, "Leave the network and broadcast addresses out of IPv4 CIDR ranges (default: only for /24 and larger)")
+	resolveOnly := fs.Bool("resolve-only", false, "Resolve the targets (hosts, IPs, CIDRs, comma-separated) and exit without scanning")
+	fs.Parse(args)
+	if *outputAll != "" {
+		outputs = append(outputs, nmapOutputs(*outputAll)...)
+	}
+
+	if *showVersion {
+		fmt.Print(versionString())
+		return
+	}
+
+	if legacy && len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "Warning: top-level flags are deprecated and will be removed in the next release; use 'port-scanner scan' or 'port-scanner web'")
+	}
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	var err error
+	if *timeoutMs, err = durationFlagMs(setFlags, "timeout", *timeoutMs, *timeoutDur); err != nil {
+		fmt.Printf("Validation error: %v\n", err)
+		os.Exit(1)
+	}
+	if *jitterMs, err = durationFlagMs(setFlags, "jitter", *jitterMs, *jitterDur); err != nil {
+		fmt.Printf("Validation error: %v\n", err)
+		os.Exit(1)
+	}
+	if setFlags["skip-network-broadcast"] {
+		skipNetworkBroadcast = skipEdges
+	}
+
+	if *dnsServer != "" {
+		if err := UseDNSServer(*dnsServer); err != nil {
+			fmt.Printf("Validation error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if resolveTimeout <= 0 {
+		fmt.Println("Validation error: -resolve-timeout must be positive")
+		os.Exit(1)
+	}
 
 	// Web mode
-	if *webMode {
-		AddWebInterface()
+	if webMode != nil && *webMode {
+		AddWebInterface(defaultWebOptions())
+		return
+	}
+
+	if *bench {
+		runBench(time.Duration(*timeoutMs)*time.Millisecond, *jsonOutput)
+		return
+	}
+
+	if *resolveOnly {
+		specs := fs.Args()
+		if *host != "" {
+			specs = append([]string{*host}, specs...)
+		}
+		runResolveOnly(specs, *jsonOutput)
 		return
 	}
 
 	// CLI mode
-	if *host == "" && len(flag.Args()) > 0 {
-		*host = flag.Arg(0)
+	if *host == "" && fs.NArg() > 0 {
+		*host = fs.Arg(0)
 	}
 
-	if *host == "" {
-		fmt.Println("Usage:")
-		fmt.Println("  port-scanner -web                        # Start web interface")
-		fmt.Println("  port-scanner -host example.com -start 1 -end 1000  # CLI mode")
-		fmt.Println("  port-scanner example.com                 # Quick scan")
-		flag.PrintDefaults()
+	if *host == "" && *srv == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	maxConcurrent, perCPU, err := parseConcurrency(*concurrent)
+	if err != nil {
+		fmt.Printf("Validation error: %v\n", err)
 		os.Exit(1)
 	}
 
 	req := ScanRequest{
-		Host:          *host,
-		StartPort:     *startPort,
-		EndPort:       *endPort,
-		MaxConcurrent: *maxConcurrent,
-		TimeoutMs:     *timeoutMs,
+		Host:              *host,
+		SRV:               *srv,
+		StartPort:         *startPort,
+		EndPort:           *endPort,
+		MaxConcurrent:     maxConcurrent,
+		ConcurrencyPerCPU: perCPU,
+		HostConcurrency:   *hostConcurrency,
+		Interleave:        *interleave,
+		TimeoutMs:         *timeoutMs,
+		TCPConcurrency:    *tcpConcurrent,
+		UDPConcurrency:    *udpConcurrent,
+		TCPTimeoutMs:      *tcpTimeout,
+		UDPTimeoutMs:      *udpTimeout,
+		Verify:            *verify,
+		JitterMs:          *jitterMs,
+		StreamOnly:        *streamOnly,
+		DualStack:         *dualStack,
+		Dedupe:            *dedupe,
+		ProgressEvery:     *progressEvery,
+		FailFast:          *failFast,
+		HTTPProbe:         *httpProbe || *followRedirects || *httpPath != "" || *httpMethod != "" || len(httpHeaders) > 0,
+		FollowRedirects:   *followRedirects,
+		HTTPPath:          *httpPath,
+		HTTPMethod:        *httpMethod,
+		HTTPHeaders:       httpHeaders,
+		ClosedSample:      *closedSample,
+		MaxDials:          *maxDials,
+		MaxDialsMode:      *maxDialsMode,
+		TLSProbe:          *tlsProbe || *tlsSNI != "" || *tlsALPN != "" || *certExpiryWarn > 0,
+		TLSServerName:     *tlsSNI,
+		VirtualHost:       *vhost,
+		FilteredTimeoutMs: int(filteredTimeout.Milliseconds()),
+		QUICProbe:         *quicProbe,
+		GrabBanner:        *grabBanner || len(expectBanner) > 0,
+		MeasureTTFB:       *measureTTFB,
+		Retries:           *retries,
+		SlowStart:         *slowStart,
+		TargetFriendly:    *targetFriendly,
+		TCPInfo:           *tcpInfo,
+		ExpectBanner:      expectBanner,
+		TimeoutOverrides:  timeoutOverrides,
+		SYN:               *synScan,
+		SourcePorts:       *sourcePorts,
+		Proxies:           proxies,
+		DeadHostThreshold: *deadHost,
+	}
+	if req.SYN {
+		if err := synScanAvailable(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; falling back to connect scan\n", err)
+			req.SYN = false
+		}
+	}
+	if *tlsALPN != "" {
+		req.TLSALPN = strings.Split(*tlsALPN, ",")
+	}
+	req.CertExpiryWarnDays = *certExpiryWarn
+	req.SortBy = *sortBy
+	req.DetectResets = *detectResets
+	req.IdempotencyKey = *idempotencyKey
+	req.SkipWildcardCheck = *noWildcardCheck
+	if *retryBackoffBase <= 0 || *retryBackoffMax <= 0 {
+		fmt.Println("Validation error: -retry-backoff-base and -retry-backoff-max must be positive")
+		os.Exit(1)
+	}
+	req.RetryBackoff = *retryBackoff
+	req.RetryBackoffMs = int(retryBackoffBase.Milliseconds())
+	req.RetryBackoffMaxMs = int(retryBackoffMax.Milliseconds())
+
+	if *freqFile != "" {
+		warnings, err := LoadFrequencyFile(*freqFile)
+		if err != nil {
+			fmt.Printf("Frequency file error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+	if countTrue(*portSpec != "", *topPorts > 0, *portsFile != "", *ephemeral) > 1 {
+		fmt.Println("Validation error: -p, -top-ports, -ports-file and -ephemeral cannot be used together")
+		os.Exit(1)
+	}
+	if *portSpec != "" {
+		specs, err := ParsePortSpec(*portSpec)
+		if err != nil {
+			fmt.Printf("Validation error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, spec := range specs {
+			if spec.Protocol == protoUDP {
+				req.UDPPorts = append(req.UDPPorts, spec.Port)
+			} else {
+				req.Ports = append(req.Ports, spec.Port)
+			}
+		}
+	}
+	if *ephemeral {
+		req.Ports = EphemeralSample(ephemeralSampleRate)
+		if !setFlags["timeout"] && !setFlags["timeout-dur"] {
+			req.TimeoutMs = ephemeralTimeoutMs
+		}
+	}
+	if *topPorts > 0 {
+		req.Ports = TopPortList(*topPorts)
+	}
+	if *portsFile != "" {
+		ports, err := LoadPortFile(*portsFile)
+		if err != nil {
+			fmt.Printf("Ports file error:\n%v\n", err)
+			os.Exit(1)
+		}
+		if len(ports) == 0 {
+			fmt.Printf("Ports file error: %s contains no ports\n", *portsFile)
+			os.Exit(1)
+		}
+		req.Ports = ports
+	}
+	req.KnownOnly = *knownOnly
+	if *alsoPorts != "" {
+		ports, err := ParsePortList(*alsoPorts)
+		if err != nil {
+			fmt.Printf("Validation error: -also: %v\n", err)
+			os.Exit(1)
+		}
+		req.AlsoPorts = ports
+	}
+	if *shard != "" {
+		index, count, err := ParseShard(*shard)
+		if err != nil {
+			fmt.Printf("Validation error: %v\n", err)
+			os.Exit(1)
+		}
+		req.ShardIndex, req.ShardCount = index, count
+	}
+	if *excludePorts != "" {
+		ports, err := ParsePortList(*excludePorts)
+		if err != nil {
+			fmt.Printf("Validation error: -exclude: %v\n", err)
+			os.Exit(1)
+		}
+		req.ExcludePorts = ports
+	}
+
+	if *allowlistFile != "" {
+		allowlist, err := LoadPortFile(*allowlistFile)
+		if err != nil {
+			fmt.Printf("Allowlist error: %v\n", err)
+			os.Exit(1)
+		}
+		req.Allowlist = allowlist
+		if req.Allowlist == nil {
+			req.Allowlist = []int{}
+		}
 	}
 
 	if err := ValidateScanRequest(req); err != nil {
@@ -52,29 +417,776 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Show progress unless JSON output or quiet mode is enabled
-	verbose := !*jsonOutput && !*quiet
-	response := RunScan(req, verbose)
+	// A delta scan narrows the ports to re-check once a baseline exists
+	var deltaBaseline *ScanResponse
+	if *deltaFrom != "" {
+		if *deltaSample < 0 {
+			fmt.Println("Validation error: -delta-sample cannot be negative")
+			os.Exit(1)
+		}
+		if req.SRV != "" || req.ShardCount > 0 || *watchFile != "" || *anonymize {
+			fmt.Println("Validation error: -delta-from cannot be combined with -srv, -shard, -watch or -anonymize")
+			os.Exit(1)
+		}
+		baseline, err := LoadScanResponse(*deltaFrom)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			// The first run is a full scan, saved as the baseline afterwards
+		case err != nil:
+			fmt.Printf("Baseline error: %v\n", err)
+			os.Exit(1)
+		case baseline.Target != req.Host:
+			fmt.Printf("Validation error: baseline %s is for %s, not %s\n", *deltaFrom, baseline.Target, req.Host)
+			os.Exit(1)
+		default:
+			var kept ScanResponse
+			if req, kept, err = PlanDelta(req, baseline, *deltaSample); err != nil {
+				fmt.Printf("Validation error: %v\n", err)
+				os.Exit(1)
+			}
+			deltaBaseline = &kept
+		}
+	}
+
+	if *jsonOutput && *jsonPortsOnly {
+		fmt.Println("Validation error: -json and -json-ports-only cannot be used together")
+		os.Exit(1)
+	}
+	if *markdown && (*jsonOutput || *jsonPortsOnly) {
+		fmt.Println("Validation error: -markdown cannot be combined with -json")
+		os.Exit(1)
+	}
+	if *portList && (*jsonOutput || *jsonPortsOnly || *markdown) {
+		fmt.Println("Validation error: -list cannot be combined with -json or -markdown")
+		os.Exit(1)
+	}
+	if *stixOutput && (*jsonOutput || *jsonPortsOnly || *markdown || *portList) {
+		fmt.Println("Validation error: -stix cannot be combined with -json, -markdown or -list")
+		os.Exit(1)
+	}
+	machineOutput := *jsonOutput || *jsonPortsOnly || *markdown || *portList || *stixOutput
+
+	var fields FieldSet
+	if *fieldList != "" {
+		if !*jsonOutput && !*jsonPortsOnly {
+			fmt.Println("Validation error: -fields needs -json or -json-ports-only")
+			os.Exit(1)
+		}
+		var err error
+		if fields, err = ParseFields(*fieldList); err != nil {
+			fmt.Printf("Validation error: -fields: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Parse the output template up front so errors surface before scanning
+	var tmpl *template.Template
+	if *templateText != "" || *templateFile != "" {
+		if machineOutput {
+			fmt.Println("Template error: -template cannot be combined with -json or -markdown")
+			os.Exit(1)
+		}
+		var err error
+		tmpl, err = ParseOutputTemplate(*templateText, *templateFile)
+		if err != nil {
+			fmt.Printf("Template error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *partialOnError && len(outputs) == 0 {
+		fmt.Println("Validation error: -partial-on-error needs at least one -out file")
+		os.Exit(1)
+	}
+
+	var signKey []byte
+	if *signKeyFile != "" {
+		if len(outputs) == 0 {
+			fmt.Println("Validation error: -sign-key needs at least one -out file to sign")
+			os.Exit(1)
+		}
+		var err error
+		signKey, err = LoadSignKey(*signKeyFile)
+		if err != nil {
+			fmt.Printf("Signing key error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *sqlitePath != "" && exportSQLite == nil {
+		fmt.Println("Validation error: SQLite export is not built in; rebuild with -tags sqlite")
+		os.Exit(1)
+	}
+
+	var webhookKey []byte
+	if *webhookURL != "" {
+		if _, err := ParseWebhookURL(*webhookURL); err != nil {
+			fmt.Printf("Validation error: %v\n", err)
+			os.Exit(1)
+		}
+		if *webhookKeyFile != "" {
+			var err error
+			if webhookKey, err = LoadSignKey(*webhookKeyFile); err != nil {
+				fmt.Printf("Webhook key error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	} else if *webhookKeyFile != "" {
+		fmt.Println("Validation error: -webhook-key needs -webhook")
+		os.Exit(1)
+	}
+
+	if *streamOnly && (machineOutput || tmpl != nil || len(outputs) > 0 || *sqlitePath != "") {
+		fmt.Println("Validation error: -stream only supports the plain text output")
+		os.Exit(1)
+	}
+
+	if *transitions && *watchFile == "" {
+		fmt.Println("Validation error: -transitions needs -watch")
+		os.Exit(1)
+	}
+	if *watchFile != "" {
+		if *jsonPortsOnly || *markdown || *stixOutput || tmpl != nil || *streamOnly || len(outputs) > 0 || *ndjsonPath != "" || *anonymize || *webhookURL != "" {
+			fmt.Println("Validation error: -watch only supports the plain text and -json output")
+			os.Exit(1)
+		}
+		if *watchInterval < minScheduleInterval {
+			fmt.Printf("Validation error: -interval must be at least %v\n", minScheduleInterval)
+			os.Exit(1)
+		}
+		format := watchText
+		switch {
+		case *transitions:
+			format = watchTransitions
+		case *jsonOutput:
+			format = watchJSON
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := RunWatch(ctx, req, *watchFile, *watchInterval, os.Stdout, format); err != nil {
+			fmt.Printf("Watch error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Progress lines name the host, so they are left out when anonymizing
+	var anon *Anonymizer
+	if *anonymize {
+		anon = NewAnonymizer()
+	}
+
+	// Show progress unless JSON output, template output, streaming or quiet mode is enabled
+	verbose := !machineOutput && tmpl == nil && !*streamOnly && !*quiet && anon == nil
+
+	// In stream mode open ports are printed the moment they are found
+	var callbacks ScanCallbacks
+	if *streamOnly {
+		fmt.Println("PORT     SERVICE")
+		callbacks.OnOpen = func(port PortInfo) {
+			fmt.Println(formatPortLine(port))
+		}
+	}
+
+	// Push each open port to the message queue as it is found
+	var publisher *asyncPublisher
+	if *publishURL != "" {
+		backend, err := NewPublisher(*publishURL, anon.Name(req.Host))
+		if err != nil {
+			fmt.Printf("Publish error: %v\n", err)
+			os.Exit(1)
+		}
+		publisher = newAsyncPublisher(backend)
+		onOpen := callbacks.OnOpen
+		callbacks.OnOpen = func(port PortInfo) {
+			publisher.Publish(port)
+			if onOpen != nil {
+				onOpen(port)
+			}
+		}
+	}
+
+	// Write each open port to disk as it is found so a crash loses nothing
+	var ndjson *ndjsonFile
+	if *ndjsonPath != "" {
+		var err error
+		ndjson, err = openNDJSONFile(*ndjsonPath, anon.Name(req.Host))
+		if err != nil {
+			fmt.Printf("NDJSON file error: %v\n", err)
+			os.Exit(1)
+		}
+		onOpen := callbacks.OnOpen
+		callbacks.OnOpen = func(port PortInfo) {
+			if err := ndjson.Write(port); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to write port %d to %s: %v\n", port.Port, *ndjsonPath, err)
+			}
+			if onOpen != nil {
+				onOpen(port)
+			}
+		}
+	}
+
+	// Skip the ports an interrupted run against the same file finished. The
+	// progress file names real addresses, so it is not kept when anonymizing.
+	var progress *scanProgress
+	if ndjson != nil && anon == nil {
+		var err error
+		progress, err = openScanProgress(progressPath(*ndjsonPath), req.Host)
+		if err != nil {
+			fmt.Printf("NDJSON file error: %v\n", err)
+			os.Exit(1)
+		}
+		if verbose && progress.Resumed() > 0 {
+			fmt.Printf("Resuming: skipping %d ports finished by an earlier run\n", progress.Resumed())
+		}
+		callbacks.Skip = progress.Skip
+		callbacks.OnDone = progress.Done
+	}
+
+	if anon != nil && callbacks.OnOpen != nil {
+		onOpen := callbacks.OnOpen
+		callbacks.OnOpen = func(port PortInfo) {
+			onOpen(anon.Port(port))
+		}
+	}
+
+	// Stop dispatching new connections on Ctrl+C and report what was found
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	response := RunScanContext(ctx, req, verbose, callbacks)
+	if publisher != nil {
+		publisher.Close()
+	}
+	if progress != nil {
+		progress.Restore(&response, ndjson.saved, req)
+		if err := progress.Close(response.Status == StatusComplete); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save scan progress: %v\n", err)
+		}
+	}
+	if ndjson != nil {
+		ndjson.Close()
+	}
+	if *groupByCategory {
+		response.Categories = GroupByCategory(response.OpenPorts)
+	}
+	if *byService {
+		response.ServiceCounts = SummarizeByService(response)
+	}
+	if *insights {
+		response.Insights = BuildInsights(response.OpenPorts)
+	}
+	anon.Response(&response)
+	if *includeCmdline {
+		response.Meta.CommandLine = CommandLine(os.Args)
+	}
 
 	// Display results
-	if *jsonOutput {
-		jsonResponse, _ := json.MarshalIndent(response, "", "  ")
+	if tmpl != nil {
+		output, err := RenderTemplate(tmpl, response)
+		if err != nil {
+			fmt.Printf("Template error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(output)
+	} else if *jsonOutput {
+		var output any = response
+		if fields != nil {
+			projected, err := ProjectResponse(response, fields)
+			if err != nil {
+				fmt.Printf("Output error: %v\n", err)
+				os.Exit(1)
+			}
+			output = projected
+		}
+		jsonResponse, _ := json.MarshalIndent(output, "", "  ")
 		fmt.Println(string(jsonResponse))
+	} else if *jsonPortsOnly {
+		ports := response.OpenPorts
+		if ports == nil {
+			ports = []PortInfo{}
+		}
+		var output any = ports
+		if fields != nil {
+			projected, err := ProjectPorts(ports, fields)
+			if err != nil {
+				fmt.Printf("Output error: %v\n", err)
+				os.Exit(1)
+			}
+			output = projected
+		}
+		jsonPorts, _ := json.MarshalIndent(output, "", "  ")
+		fmt.Println(string(jsonPorts))
+	} else if *markdown {
+		fmt.Print(FormatMarkdown(response))
+	} else if *stixOutput {
+		bundle, err := FormatSTIX(response)
+		if err != nil {
+			fmt.Printf("STIX error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(bundle))
+	} else if *portList {
+		fmt.Print(FormatPortList(response))
+		// stdout carries only port numbers, so the error goes to stderr
+		if response.Error != "" {
+			fmt.Fprintf(os.Stderr, "Scan error: %s\n", response.Error)
+		}
 	} else {
-		fmt.Printf("\nScan Results for %s:\n", response.Target)
-		fmt.Printf("Scanned ports %d-%d in %.2f seconds\n",
-			response.StartPort, response.EndPort, response.DurationSeconds)
-		fmt.Printf("Found %d open ports out of %d total ports\n\n",
-			len(response.OpenPorts), response.TotalPorts)
-
-		if len(response.OpenPorts) > 0 {
-			fmt.Println("Open ports:")
-			fmt.Println("PORT     SERVICE")
-			for _, port := range response.OpenPorts {
-				fmt.Printf("%-8d %s\n", port.Port, port.Service)
+		writeTextReport(os.Stdout, req, response)
+		if deltaBaseline != nil && response.Error == "" {
+			writeDeltaReport(os.Stdout, *deltaFrom, *deltaBaseline, response)
+		}
+		if verbose && response.Status != StatusError {
+			fmt.Printf("\nTiming: resolve %.1f ms, scan %.1f ms, probes %.1f ms (summed over open ports)\n",
+				response.Timing.ResolveMs, response.Timing.ScanMs, response.Timing.ProbeMs)
+		}
+		if *streamOnly && response.Error != "" {
+			os.Exit(1)
+		}
+	}
+
+	if len(outputs) > 0 {
+		// A truncated scan finished the connections it was allowed
+		partial := *partialOnError && response.Status != StatusComplete && response.Status != StatusTruncated
+		if err := WriteOutputFiles(outputs, req, response, partial); err != nil {
+			fmt.Printf("Output error: %v\n", err)
+			os.Exit(1)
+		}
+		if signKey != nil {
+			for _, out := range outputs {
+				if err := SignFile(out.Destination(partial), signKey); err != nil {
+					fmt.Printf("Signing error: %v\n", err)
+					os.Exit(1)
+				}
 			}
-		} else {
-			fmt.Println("No open ports found.")
 		}
 	}
+
+	if *sqlitePath != "" {
+		if err := exportSQLite(*sqlitePath, response); err != nil {
+			fmt.Printf("SQLite error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Only a full scan that finished becomes the delta baseline
+	if *deltaFrom != "" && deltaBaseline == nil && response.Status == StatusComplete {
+		if err := saveBaseline(*deltaFrom, response); err != nil {
+			fmt.Printf("Baseline error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "Saved full scan to %s; later runs re-check only its open ports and a sample\n", *deltaFrom)
+	}
+
+	// A dashboard being down must not turn a good scan into a failure
+	if *webhookURL != "" {
+		if err := PostWebhook(context.Background(), *webhookURL, response, webhookKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: webhook delivery failed: %v\n", err)
+		}
+	}
+
+	if response.Error != "" {
+		os.Exit(1)
+	}
+	// Report every failed check before exiting, so one cannot hide another
+	if !machineOutput && tmpl == nil {
+		if len(response.BannerMismatches) > 0 {
+			fmt.Printf("\nBanner mismatches: %v\n", response.BannerMismatches)
+		}
+		if len(response.Violations) > 0 {
+			fmt.Printf("\nAllowlist violations: %v\n", response.Violations)
+		}
+		if len(response.ExpiringCerts) > 0 {
+			fmt.Printf("\nCertificates expiring within %d days: %v\n", *certExpiryWarn, response.ExpiringCerts)
+		}
+	}
+	if len(response.BannerMismatches) > 0 || len(response.Violations) > 0 || len(response.ExpiringCerts) > 0 {
+		os.Exit(exitCheckFailed)
+	}
+}
+
+// runResolveOnly resolves each target and reports its addresses or the error,
+// exiting with status 1 if any target failed
+func runResolveOnly(specs []string, jsonOutput bool) {
+	targets, err := ExpandTargets(specs)
+	if err != nil {
+		fmt.Printf("Target error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(targets) == 0 {
+		fmt.Println("Target error: no targets given")
+		os.Exit(1)
+	}
+
+	results := make([]ResolveResult, 0, len(targets))
+	failed := false
+	for _, t := range targets {
+		result := ResolveResult{Target: t.Host, CIDR: t.CIDR}
+		if t.Unicode != "" {
+			result.Target, result.Punycode = t.Unicode, t.Host
+		}
+		addrs, err := ResolveHost(t.Host)
+		if err != nil {
+			result.Error = err.Error()
+			failed = true
+		}
+		result.Addresses = addrs
+		results = append(results, result)
+	}
+
+	if jsonOutput {
+		jsonResults, _ := json.MarshalIndent(results, "", "  ")
+		fmt.Println(string(jsonResults))
+	} else {
+		for _, r := range results {
+			line := r.Target
+			if r.Punycode != "" {
+				line += " (" + r.Punycode + ")"
+			}
+			if r.CIDR != "" {
+				line += " (in " + r.CIDR + ")"
+			}
+			if r.Error != "" {
+				fmt.Printf("%-30s error: %s\n", line, r.Error)
+			} else {
+				fmt.Printf("%-30s %s\n", line, strings.Join(r.Addresses, ", "))
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runBench runs the benchmark and prints its results
+func runBench(timeout time.Duration, jsonOutput bool) {
+	if !jsonOutput {
+		fmt.Printf("Benchmarking against %d local listeners, at least %d dials per level...\n\n",
+			benchPortCount, benchDialsPerLevel)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	levels, err := RunBenchmark(ctx, timeout)
+	if err != nil && len(levels) == 0 {
+		fmt.Printf("Benchmark error: %v\n", err)
+		os.Exit(1)
+	}
+	if jsonOutput {
+		jsonLevels, _ := json.MarshalIndent(levels, "", "  ")
+		fmt.Println(string(jsonLevels))
+	} else {
+		writeBenchTable(os.Stdout, levels)
+	}
+}
+
+// formatPortLine renders one open port for the plain text table
+func formatPortLine(port PortInfo) string {
+	line := fmt.Sprintf("%-8s %s", portLabel(port), port.Service)
+	if len(port.FoundOn) > 1 {
+		line += " on " + strings.Join(port.FoundOn, ", ")
+	} else if port.IP != "" {
+		line += " on " + port.IP
+	}
+	if port.Banner != "" {
+		line += fmt.Sprintf(" %q", firstLine(port.Banner))
+	}
+	switch {
+	case port.TTFBMs > 0:
+		line += fmt.Sprintf(" [speaks first, %.2f ms]", port.TTFBMs)
+	case port.TTFBMs == ttfbClosed:
+		line += " [closed without sending]"
+	case port.TTFBMs < 0:
+		line += " [waits for client]"
+	}
+	if port.VirtualHost != "" {
+		line += " [vhost " + port.VirtualHost + "]"
+	}
+	if port.HTTPStatus != 0 {
+		line += fmt.Sprintf(" [HTTP %d", port.HTTPStatus)
+		if port.HTTPServer != "" {
+			line += " " + port.HTTPServer
+		}
+		if port.HTTPRedirect != "" {
+			line += " -> " + port.HTTPRedirect
+		}
+		line += "]"
+	}
+	if port.TLS {
+		line += " [TLS"
+		if port.ALPN != "" {
+			line += " " + port.ALPN
+		}
+		if port.TLSSubject != "" {
+			line += " CN=" + port.TLSSubject
+		}
+		if port.CertExpiringSoon && port.CertNotAfter != nil {
+			line += " expires " + port.CertNotAfter.Format(time.DateOnly)
+		}
+		line += "]"
+	}
+	if port.Suspicious {
+		line += " [suspicious: closed on connect]"
+	}
+	if port.QUIC {
+		line += " [QUIC"
+		if port.QUICALPN != "" {
+			line += " " + port.QUICALPN
+		}
+		line += "]"
+	}
+	if port.Unexpected {
+		line += " (not in allowlist)"
+	}
+	if port.ExpectationMet != nil && !*port.ExpectationMet {
+		line += " (banner mismatch)"
+	}
+	return line
+}
+
+// countTrue counts how many of the conditions hold
+func countTrue(conds ...bool) int {
+	n := 0
+	for _, c := range conds {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+// parseConcurrency parses the -concurrent flag: a fixed number of
+// connections, "Nx" for N per CPU, or "auto" (or 0) for a limit picked from
+// the CPU count and open file limit
+func parseConcurrency(s string) (fixed, perCPU int, err error) {
+	if s == "auto" || s == "0" {
+		return 0, 0, nil
+	}
+	if n, ok := strings.CutSuffix(s, "x"); ok {
+		perCPU, err = strconv.Atoi(n)
+		if err != nil || perCPU < 1 {
+			return 0, 0, fmt.Errorf("invalid -concurrent %q: the multiplier must be a positive integer", s)
+		}
+		return 0, perCPU, nil
+	}
+	fixed, err = strconv.Atoi(s)
+	if err != nil || fixed < 1 {
+		return 0, 0, fmt.Errorf("invalid -concurrent %q: use a positive number, Nx, or auto (or 0)", s)
+	}
+	return fixed, 0, nil
+}
+
+// firstLine returns text up to the first newline
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return strings.TrimSpace(line)
+}
+
+// durationFlagMs resolves a millisecond flag against its -<name>-dur
+// counterpart. The duration form wins when both are given. A positive
+// duration under 1ms is rejected, since it would truncate to 0 and so to
+// the default.
+func durationFlagMs(setFlags map[string]bool, name string, ms int, dur time.Duration) (int, error) {
+	if !setFlags[name+"-dur"] {
+		return ms, nil
+	}
+	if dur > 0 && dur < time.Millisecond {
+		return 0, fmt.Errorf("-%s-dur %v is below the 1ms resolution", name, dur)
+	}
+	if setFlags[name] {
+		fmt.Fprintf(os.Stderr, "Warning: both -%s and -%s-dur given; using -%s-dur %v\n", name, name, name, dur)
+	}
+	return int(dur.Milliseconds()), nil
+}
+
+// runWeb implements the web command
+func runWeb(args []string) {
+	fs := flag.NewFlagSet("web", flag.ExitOnError)
+	opts := defaultWebOptions()
+	fs.StringVar(&opts.Addr, "listen", opts.Addr, "Address for the web server to listen on")
+	fs.Int64Var(&opts.MaxBodyBytes, "max-body", opts.MaxBodyBytes, "Maximum size in bytes of a scan request body")
+	fs.IntVar(&opts.HistoryMaxEntries, "history-max-entries", opts.HistoryMaxEntries, "Most scan results kept in history (0 = unbounded)")
+	fs.IntVar(&opts.HistoryMaxBytes, "history-max-bytes", opts.HistoryMaxBytes, "Approximate maximum size of the history in bytes (0 = unbounded)")
+	fs.IntVar(&opts.GlobalConcurrency, "global-concurrency", 0, "Maximum dials in flight across all concurrent scans (0 = unbounded)")
+	fs.DurationVar(&opts.CacheTTL, "cache-ttl", 0, "Answer identical scan requests from a cache for this long, e.g. 30s (0 = off)")
+	fs.IntVar(&opts.ScanWorkers, "scan-workers", 0, "Queue scans as jobs run by this many workers, answering 202 with a job ID (0 = run each scan as it arrives)")
+	fs.IntVar(&opts.QueueSize, "queue-size", opts.QueueSize, "Most scan jobs waiting for a worker before new ones are refused")
+	dnsServer := fs.String("dns", "", "Resolve scan targets with this DNS server (e.g. 10.0.0.53:53) instead of the system resolver")
+	fs.DurationVar(&resolveTimeout, "resolve-timeout", defaultResolveTimeout, "Give up resolving a scan target name after this long")
+	fs.Var((*cidrListFlag)(&scanPolicy.Allow), "allow-cidr", "Only scan targets inside this CIDR (repeatable)")
+	fs.Var((*cidrListFlag)(&scanPolicy.Deny), "deny-cidr", "Never scan targets inside this CIDR, even if allowed (repeatable)")
+	allowPrivate := fs.Bool("allow-private", true, "Allow scanning private and link-local targets (loopback is always allowed)")
+	fs.Parse(args)
+	scanPolicy.DenyPrivate = !*allowPrivate
+
+	if *dnsServer != "" {
+		if err := UseDNSServer(*dnsServer); err != nil {
+			fmt.Printf("Validation error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if resolveTimeout <= 0 {
+		fmt.Println("Validation error: -resolve-timeout must be positive")
+		os.Exit(1)
+	}
+
+	if opts.MaxBodyBytes <= 0 {
+		fmt.Println("Validation error: -max-body must be positive")
+		os.Exit(1)
+	}
+	if opts.HistoryMaxEntries < 0 || opts.HistoryMaxBytes < 0 {
+		fmt.Println("Validation error: history limits cannot be negative")
+		os.Exit(1)
+	}
+	if opts.GlobalConcurrency < 0 {
+		fmt.Println("Validation error: -global-concurrency cannot be negative")
+		os.Exit(1)
+	}
+	if opts.CacheTTL < 0 {
+		fmt.Println("Validation error: -cache-ttl cannot be negative")
+		os.Exit(1)
+	}
+	if opts.ScanWorkers < 0 {
+		fmt.Println("Validation error: -scan-workers cannot be negative")
+		os.Exit(1)
+	}
+	if opts.QueueSize <= 0 {
+		fmt.Println("Validation error: -queue-size must be positive")
+		os.Exit(1)
+	}
+	AddWebInterface(opts)
+}
+
+// runDiff implements the diff command, comparing two saved JSON results
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "Output in JSON format (the same as -diff-format json)")
+	diffFormat := fs.String("diff-format", "text", "Output format: text, json or patch (a unified diff of the open ports)")
+	fs.Usage = func() {
+		fmt.Println("Usage: port-scanner diff [-diff-format text|json|patch] old.json new.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *jsonOutput {
+		*diffFormat = "json"
+	}
+	if *diffFormat != "text" && *diffFormat != "json" && *diffFormat != "patch" {
+		fmt.Println("Validation error: -diff-format must be text, json or patch")
+		os.Exit(1)
+	}
+
+	oldScan, err := LoadScanResponse(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	newScan, err := LoadScanResponse(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *diffFormat == "patch" {
+		fmt.Print(FormatPatch(oldScan, newScan, fs.Arg(0), fs.Arg(1)))
+		return
+	}
+	diff := DiffScans(oldScan, newScan)
+	if *diffFormat == "json" {
+		jsonDiff, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(jsonDiff))
+		return
+	}
+
+	if len(diff.Opened) == 0 && len(diff.Closed) == 0 {
+		fmt.Println("No changes in open ports.")
+		return
+	}
+	for _, port := range diff.Opened {
+		fmt.Printf("+ %s (newly open)\n", formatPortLine(port))
+	}
+	for _, port := range diff.Closed {
+		fmt.Printf("- %s (no longer open)\n", formatPortLine(port))
+	}
+}
+
+// runMerge implements the merge command, combining saved results for one
+// target into a single JSON result
+func runMerge(args []string) {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Println("Usage: port-scanner merge result.json result.json...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	responses := make([]ScanResponse, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		resp, err := LoadScanResponse(path)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		responses = append(responses, resp)
+	}
+	merged, err := MergeResponses(responses)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	jsonMerged, _ := json.MarshalIndent(merged, "", "  ")
+	fmt.Println(string(jsonMerged))
+}
+
+// runVerifySig implements the verify-sig command, checking saved results
+// against the signatures written by scan -sign-key
+func runVerifySig(args []string) {
+	fs := flag.NewFlagSet("verify-sig", flag.ExitOnError)
+	keyFile := fs.String("key", "", "File containing the HMAC key the results were signed with")
+	fs.Usage = func() {
+		fmt.Println("Usage: port-scanner verify-sig -key k.key result.json [result.csv ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *keyFile == "" || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	key, err := LoadSignKey(*keyFile)
+	if err != nil {
+		fmt.Printf("Signing key error: %v\n", err)
+		os.Exit(1)
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		switch err := VerifyFile(path, key); {
+		case err == nil:
+			fmt.Printf("%s: OK\n", path)
+		case errors.Is(err, errSignatureMismatch):
+			fmt.Printf("%s: FAILED (%v)\n", path, err)
+			status = max(status, exitCheckFailed)
+		default:
+			fmt.Printf("%s: error: %v\n", path, err)
+			status = max(status, 1)
+		}
+	}
+	os.Exit(status)
+}
+
+// runVersion implements the version command
+func runVersion(args []string) {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.Parse(args)
+
+	fmt.Print(versionString())
 }
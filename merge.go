@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// statusRank orders scan statuses from best to worst, so a merged result
+// reports the worst status of its parts
+var statusRank = map[string]int{
+	StatusComplete:  0,
+	StatusTruncated: 1,
+	StatusDeadline:  2,
+	StatusCancelled: 3,
+	StatusError:     4,
+}
+
+// MergeResponses combines results for the same target into one, such as
+// the shards of a sharded scan or several passes over the same ports.
+//
+// Open ports are unioned, keeping the first copy of a port seen on the same
+// address and protocol. Results are treated as disjoint when they are
+// shards of the same split or cover non-overlapping port ranges, and their
+// port counts are summed; results over the same port range are treated as
+// repeated passes, where the largest total counts and a port open in any
+// pass is open. Durations and timing phases are summed, giving the time
+// spent scanning across all parts. It returns an error when the results
+// name different targets, mix shards with unsharded scans or shard counts,
+// repeat a shard, or cover partially overlapping port ranges.
+func MergeResponses(responses []ScanResponse) (ScanResponse, error) {
+	if len(responses) == 0 {
+		return ScanResponse{}, errors.New("no results to merge")
+	}
+	first := responses[0]
+	for _, resp := range responses[1:] {
+		if !strings.EqualFold(strings.TrimSpace(resp.Target), strings.TrimSpace(first.Target)) {
+			return ScanResponse{}, fmt.Errorf("results target different hosts: %q and %q", first.Target, resp.Target)
+		}
+	}
+	disjoint, err := mergeLayout(responses)
+	if err != nil {
+		return ScanResponse{}, err
+	}
+
+	merged := ScanResponse{
+		Target:         first.Target,
+		StartPort:      first.StartPort,
+		EndPort:        first.EndPort,
+		Shard:          mergedShard(responses),
+		PunycodeTarget: first.PunycodeTarget,
+		Timestamp:      first.Timestamp,
+		Status:         StatusComplete,
+		Meta:           first.Meta,
+	}
+	type key struct {
+		ip       string
+		port     int
+		protocol string
+	}
+	keyOf := func(p PortInfo) key {
+		return key{p.IP, p.Port, nmapProtocol(p)}
+	}
+	open := make(map[key]bool)
+	closedSeen := make(map[key]bool)
+	hostsUp := make(map[string]bool)
+	var downHosts []string
+	var weight float64
+	var categories, serviceCounts, insights bool
+	for _, resp := range responses {
+		for _, port := range resp.OpenPorts {
+			if !open[keyOf(port)] {
+				open[keyOf(port)] = true
+				merged.OpenPorts = append(merged.OpenPorts, port)
+			}
+			if port.IP != "" {
+				hostsUp[port.IP] = true
+			}
+		}
+		for _, port := range resp.ClosedSample {
+			if !closedSeen[keyOf(port)] {
+				closedSeen[keyOf(port)] = true
+				merged.ClosedSample = append(merged.ClosedSample, port)
+			}
+		}
+
+		merged.StartPort = min(merged.StartPort, resp.StartPort)
+		merged.EndPort = max(merged.EndPort, resp.EndPort)
+		if disjoint {
+			merged.TotalPorts += resp.TotalPorts
+			merged.ClosedPorts += resp.ClosedPorts
+		} else {
+			merged.TotalPorts = max(merged.TotalPorts, resp.TotalPorts)
+		}
+		merged.Truncated = merged.Truncated || resp.Truncated
+		merged.ErroredPorts = unionSorted(merged.ErroredPorts, resp.ErroredPorts)
+		merged.Violations = unionSorted(merged.Violations, resp.Violations)
+		merged.BannerMismatches = unionSorted(merged.BannerMismatches, resp.BannerMismatches)
+		merged.ExpiringCerts = unionSorted(merged.ExpiringCerts, resp.ExpiringCerts)
+
+		if resp.HostUp != nil && (merged.HostUp == nil || *resp.HostUp) {
+			hostUp := *resp.HostUp
+			merged.HostUp = &hostUp
+		}
+		for _, ip := range resp.ScannedIPs {
+			if !slices.Contains(resp.DownHosts, ip) {
+				hostsUp[ip] = true
+			}
+		}
+		downHosts = appendUnique(downHosts, resp.DownHosts...)
+		merged.ResolvedIPs = appendUnique(merged.ResolvedIPs, resp.ResolvedIPs...)
+		merged.ScannedIPs = appendUnique(merged.ScannedIPs, resp.ScannedIPs...)
+		merged.SRVEndpoints = appendUnique(merged.SRVEndpoints, resp.SRVEndpoints...)
+
+		merged.DurationSeconds += resp.DurationSeconds
+		merged.Timing.ResolveMs += resp.Timing.ResolveMs
+		merged.ResolveMs = merged.Timing.ResolveMs
+		merged.Timing.ScanMs += resp.Timing.ScanMs
+		merged.Timing.ProbeMs += resp.Timing.ProbeMs
+		merged.VerifyFlipped += resp.VerifyFlipped
+		merged.Retried += resp.Retried
+		merged.RetryResolved += resp.RetryResolved
+
+		// Average concurrency is weighted by how long each part ran
+		merged.PeakConcurrency = max(merged.PeakConcurrency, resp.PeakConcurrency)
+		merged.AvgConcurrency += resp.AvgConcurrency * resp.DurationSeconds
+		weight += resp.DurationSeconds
+
+		categories = categories || resp.Categories != nil
+		serviceCounts = serviceCounts || resp.ServiceCounts != nil
+		insights = insights || resp.Insights != nil
+		merged.ScanningSelf = merged.ScanningSelf || resp.ScanningSelf
+		merged.WildcardDNS = merged.WildcardDNS || resp.WildcardDNS
+		if resp.Timestamp.Before(merged.Timestamp) {
+			merged.Timestamp = resp.Timestamp
+		}
+		if statusRank[resp.Status] > statusRank[merged.Status] {
+			merged.Status = resp.Status
+		}
+		if merged.Error == "" {
+			merged.Error = resp.Error
+		}
+	}
+
+	sortPortInfos(merged.OpenPorts)
+	sortPortInfos(merged.ClosedSample)
+	merged.ClosedSample = slices.DeleteFunc(merged.ClosedSample, func(p PortInfo) bool { return open[keyOf(p)] })
+	if !disjoint {
+		merged.ClosedPorts = max(0, merged.TotalPorts-len(merged.OpenPorts))
+	}
+	for _, ip := range downHosts {
+		if !hostsUp[ip] {
+			merged.DownHosts = append(merged.DownHosts, ip)
+		}
+	}
+	if weight > 0 {
+		merged.AvgConcurrency /= weight
+	}
+	merged.FastestPort, merged.SlowestPort = connectExtremes(merged.OpenPorts)
+
+	// Summaries are rebuilt from the merged ports when any part had them
+	if categories {
+		merged.Categories = GroupByCategory(merged.OpenPorts)
+	}
+	if serviceCounts {
+		merged.ServiceCounts = SummarizeByService(merged)
+	}
+	if insights {
+		merged.Insights = BuildInsights(merged.OpenPorts)
+	}
+	return merged, nil
+}
+
+// mergeLayout checks how the results split the ports between them and
+// reports whether they are disjoint (true) or passes over the same ports
+func mergeLayout(responses []ScanResponse) (bool, error) {
+	shards := make(map[int]bool)
+	shardCount := 0
+	for i, resp := range responses {
+		if (resp.Shard != "") != (responses[0].Shard != "") {
+			return false, errors.New("cannot merge sharded and unsharded results")
+		}
+		if resp.Shard == "" {
+			continue
+		}
+		index, count, err := ParseShard(resp.Shard)
+		if err != nil {
+			return false, err
+		}
+		if i > 0 && count != shardCount {
+			return false, fmt.Errorf("results come from different shard counts: %d and %d", shardCount, count)
+		}
+		if shards[index] {
+			return false, fmt.Errorf("shard %s appears more than once", resp.Shard)
+		}
+		shardCount = count
+		shards[index] = true
+	}
+	if shardCount > 0 {
+		return true, nil
+	}
+
+	first := responses[0]
+	same := true
+	for _, resp := range responses[1:] {
+		if resp.StartPort != first.StartPort || resp.EndPort != first.EndPort {
+			same = false
+		}
+	}
+	if same && len(responses) > 1 {
+		return false, nil
+	}
+	for i, a := range responses {
+		for _, b := range responses[i+1:] {
+			if a.StartPort <= b.EndPort && b.StartPort <= a.EndPort {
+				return false, fmt.Errorf("port ranges %d-%d and %d-%d overlap partially", a.StartPort, a.EndPort, b.StartPort, b.EndPort)
+			}
+		}
+	}
+	return true, nil
+}
+
+// mergedShard labels the merged result: no shard once every shard of the
+// split is present, otherwise the shards it covers
+func mergedShard(responses []ScanResponse) string {
+	var indexes []int
+	count := 0
+	for _, resp := range responses {
+		if resp.Shard == "" {
+			return ""
+		}
+		var index int
+		index, count, _ = ParseShard(resp.Shard)
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == count {
+		return ""
+	}
+	slices.Sort(indexes)
+	labels := make([]string, len(indexes))
+	for i, index := range indexes {
+		labels[i] = fmt.Sprintf("%d/%d", index, count)
+	}
+	return strings.Join(labels, ",")
+}
+
+// unionSorted merges two port lists into one sorted list without duplicates
+func unionSorted(a, b []int) []int {
+	if len(b) == 0 {
+		return a
+	}
+	union := slices.Concat(a, b)
+	slices.Sort(union)
+	return slices.Compact(union)
+}
+
+// appendUnique appends the values not already in list, keeping their order
+func appendUnique[T comparable](list []T, values ...T) []T {
+	for _, v := range values {
+		if !slices.Contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handleMetrics serves web server gauges in the Prometheus text format
+func handleMetrics(history *scanHistory, jobs *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		entries, bytes, evictions := history.Usage()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetric(w, "portscanner_history_entries", "gauge", "Scan results held in the history", entries)
+		writeMetric(w, "portscanner_history_bytes", "gauge", "Approximate size of the history in bytes", bytes)
+		writeMetric(w, "portscanner_history_max_entries", "gauge", "History entry limit (0 = unbounded)", history.maxEntries)
+		writeMetric(w, "portscanner_history_max_bytes", "gauge", "History size limit in bytes (0 = unbounded)", history.maxBytes)
+		writeMetric(w, "portscanner_history_evictions_total", "counter", "History entries evicted to stay within the limits", evictions)
+		writeMetric(w, "portscanner_dials_in_flight", "gauge", "Dials in flight across all scans (counted only with a global limit)", len(globalDials))
+		writeMetric(w, "portscanner_global_concurrency", "gauge", "Server-wide dial limit (0 = unbounded)", cap(globalDials))
+		queued, busy := jobs.Usage()
+		writeMetric(w, "portscanner_jobs_queued", "gauge", "Scan jobs waiting for a worker", queued)
+		writeMetric(w, "portscanner_jobs_running", "gauge", "Scan jobs being run by a worker", busy)
+	}
+}
+
+// writeMetric writes one metric with its HELP and TYPE lines
+func writeMetric(w http.ResponseWriter, name, kind, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
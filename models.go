@@ -6,31 +6,250 @@ import (
 
 // ScanRequest represents scanning parameters
 type ScanRequest struct {
-	Host          string `json:"host"`
-	StartPort     int    `json:"start_port"`
-	EndPort       int    `json:"end_port"`
-	MaxConcurrent int    `json:"max_concurrent,omitempty"`
-	TimeoutMs     int    `json:"timeout_ms,omitempty"`
+	Host              string   `json:"host"`
+	SRV               string   `json:"srv,omitempty"`
+	StartPort         int      `json:"start_port"`
+	EndPort           int      `json:"end_port"`
+	Ports             []int    `json:"ports,omitempty"`
+	UDPPorts          []int    `json:"udp_ports,omitempty"`
+	KnownOnly         bool     `json:"known_only,omitempty"`
+	AlsoPorts         []int    `json:"also_ports,omitempty"`
+	ExcludePorts      []int    `json:"exclude_ports,omitempty"`
+	ShardIndex        int      `json:"shard_index,omitempty"`
+	ShardCount        int      `json:"shard_count,omitempty"`
+	MaxConcurrent     int      `json:"max_concurrent,omitempty"`
+	ConcurrencyPerCPU int      `json:"concurrency_per_cpu,omitempty"`
+	HostConcurrency   int      `json:"host_concurrency,omitempty"`
+	Interleave        bool     `json:"interleave,omitempty"`
+	TimeoutMs         int      `json:"timeout_ms,omitempty"`
+	TCPConcurrency    int      `json:"tcp_concurrency,omitempty"`
+	UDPConcurrency    int      `json:"udp_concurrency,omitempty"`
+	TCPTimeoutMs      int      `json:"tcp_timeout_ms,omitempty"`
+	UDPTimeoutMs      int      `json:"udp_timeout_ms,omitempty"`
+	Verify            bool     `json:"verify,omitempty"`
+	JitterMs          int      `json:"jitter_ms,omitempty"`
+	StreamOnly        bool     `json:"stream_only,omitempty"`
+	Allowlist         []int    `json:"allowlist,omitempty"`
+	DualStack         bool     `json:"dual_stack,omitempty"`
+	Dedupe            bool     `json:"dedupe,omitempty"`
+	ProgressEvery     int      `json:"progress_every,omitempty"`
+	FailFast          bool     `json:"fail_fast,omitempty"`
+	HTTPProbe         bool     `json:"http_probe,omitempty"`
+	FollowRedirects   bool     `json:"follow_redirects,omitempty"`
+	HTTPPath          string   `json:"http_path,omitempty"`
+	HTTPMethod        string   `json:"http_method,omitempty"`
+	ClosedSample      int      `json:"closed_sample,omitempty"`
+	MaxDials          int      `json:"max_dials,omitempty"`
+	MaxDialsMode      string   `json:"max_dials_mode,omitempty"`
+	TLSProbe          bool     `json:"tls_probe,omitempty"`
+	TLSServerName     string   `json:"tls_server_name,omitempty"`
+	TLSALPN           []string `json:"tls_alpn,omitempty"`
+	VirtualHost       string   `json:"virtual_host,omitempty"`
+	FilteredTimeoutMs int      `json:"filtered_timeout_ms,omitempty"`
+	QUICProbe         bool     `json:"quic_probe,omitempty"`
+	GrabBanner        bool     `json:"banner,omitempty"`
+	MeasureTTFB       bool     `json:"measure_ttfb,omitempty"`
+	SYN               bool     `json:"syn,omitempty"`
+	SourcePorts       string   `json:"source_ports,omitempty"`
+	Proxies           []string `json:"proxies,omitempty"`
+	DeadHostThreshold int      `json:"dead_host_threshold,omitempty"`
+	Retries           int      `json:"retries,omitempty"`
+	RetryBackoff      string   `json:"retry_backoff,omitempty"`
+	RetryBackoffMs    int      `json:"retry_backoff_ms,omitempty"`
+	RetryBackoffMaxMs int      `json:"retry_backoff_max_ms,omitempty"`
+	SlowStart         bool     `json:"slow_start,omitempty"`
+	TargetFriendly    bool     `json:"target_friendly,omitempty"`
+	TCPInfo           bool     `json:"tcp_info,omitempty"`
+	DetectResets      bool     `json:"detect_resets,omitempty"`
+	IdempotencyKey    string   `json:"idempotency_key,omitempty"`
+	// CertExpiryWarnDays flags TLS ports whose certificate expires within
+	// this many days; it needs the TLS probe
+	CertExpiryWarnDays int `json:"cert_expiry_warn_days,omitempty"`
+	// SortBy orders the open ports in the result: SortPort (the default),
+	// SortDiscovery, SortService or SortConnectTime
+	SortBy string `json:"sort_by,omitempty"`
+	// ExpectBanner maps ports to a regular expression their banner must match
+	ExpectBanner map[int]string `json:"expect_banner,omitempty"`
+	// TimeoutOverrides maps ports to a dial timeout in milliseconds that
+	// replaces TimeoutMs for just those ports
+	TimeoutOverrides map[int]int `json:"timeout_overrides,omitempty"`
+	// HTTPHeaders are sent with every HTTP probe request; a Host entry
+	// replaces the Host header
+	HTTPHeaders map[string]string `json:"http_headers,omitempty"`
+	// SkipWildcardCheck leaves out the extra lookup that detects wildcard
+	// DNS and sinkholes, so WildcardDNS is never set
+	SkipWildcardCheck bool `json:"skip_wildcard_check,omitempty"`
 }
 
 // PortInfo contains information about a scanned port
 type PortInfo struct {
-	Port    int    `json:"port"`
-	Service string `json:"service,omitempty"`
-	State   string `json:"state"`
+	Port         int     `json:"port"`
+	Protocol     string  `json:"protocol,omitempty"`
+	IP           string  `json:"ip,omitempty"`
+	Service      string  `json:"service,omitempty"`
+	State        string  `json:"state"`
+	ConnectMs    float64 `json:"connect_ms,omitempty"`
+	Unexpected   bool    `json:"unexpected,omitempty"`
+	VirtualHost  string  `json:"virtual_host,omitempty"`
+	HTTPStatus   int     `json:"http_status,omitempty"`
+	HTTPServer   string  `json:"http_server,omitempty"`
+	HTTPRedirect string  `json:"http_redirect,omitempty"`
+	TLS          bool    `json:"tls,omitempty"`
+	TLSSubject   string  `json:"tls_subject,omitempty"`
+	ALPN         string  `json:"alpn,omitempty"`
+	QUIC         bool    `json:"quic,omitempty"`
+	QUICALPN     string  `json:"quic_alpn,omitempty"`
+	Banner       string  `json:"banner,omitempty"`
+	BannerHash   string  `json:"banner_hash,omitempty"`
+	// TTFBMs is the time to the first unsolicited byte, -1 for a service
+	// that waits for the client to speak, or -2 for one that hangs up
+	// without sending anything
+	TTFBMs float64 `json:"ttfb_ms,omitempty"`
+	// ExpectationMet is set when the port has an expected banner
+	ExpectationMet *bool  `json:"expectation_met,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// FoundOn lists every address of the host the port was open on when
+	// identical results are deduplicated
+	FoundOn []string `json:"found_on,omitempty"`
+	// TCPInfo is what the connection negotiated with the peer, recorded
+	// with -tcp-info where the OS exposes it
+	TCPInfo *TCPInfo `json:"tcp_info,omitempty"`
+	// CertNotAfter is when the certificate presented to the TLS probe
+	// expires, and CertExpiringSoon is set when that falls inside the
+	// -cert-expiry-warn window (or has already passed)
+	CertNotAfter     *time.Time `json:"cert_not_after,omitempty"`
+	CertExpiringSoon bool       `json:"cert_expiring_soon,omitempty"`
+	// Suspicious is set by -detect-resets when the port closed or reset a
+	// fresh connection at once without sending anything, as tarpits and
+	// honeypots do. It is a heuristic, not proof.
+	Suspicious bool `json:"suspicious,omitempty"`
+
+	// found is when the scan saw the port open, for SortDiscovery
+	found time.Time
+}
+
+// TCPInfo holds the TCP parameters of a connection to an open port, which
+// hint at the peer's operating system. MSS is the segment size used towards
+// the peer, PeerWindow the receive window it last advertised in bytes
+// (Linux 5.4 and later) and WindowScale the shift it announced, if any.
+type TCPInfo struct {
+	MSS         int  `json:"mss"`
+	PeerWindow  int  `json:"peer_window,omitempty"`
+	WindowScale *int `json:"window_scale,omitempty"`
+	RTTMicros   int  `json:"rtt_us"`
+	Timestamps  bool `json:"timestamps"`
+	SACK        bool `json:"sack"`
+	ECN         bool `json:"ecn"`
+}
+
+// ResolveResult is one line of the -resolve-only report
+type ResolveResult struct {
+	Target    string   `json:"target"`
+	Punycode  string   `json:"punycode,omitempty"`
+	CIDR      string   `json:"cidr,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// PortTiming records how long an open port took to accept a connection
+type PortTiming struct {
+	Port      int     `json:"port"`
+	ConnectMs float64 `json:"connect_ms"`
 }
 
 // ScanResponse contains scan results
 type ScanResponse struct {
-	Target          string     `json:"target"`
-	StartPort       int        `json:"start_port"`
-	EndPort         int        `json:"end_port"`
-	OpenPorts       []PortInfo `json:"open_ports"`
-	ClosedPorts     int        `json:"closed_ports"`
-	TotalPorts      int        `json:"total_ports"`
-	DurationSeconds float64    `json:"duration_seconds"`
-	Timestamp       time.Time  `json:"timestamp"`
-	Error           string     `json:"error,omitempty"`
+	Target           string           `json:"target"`
+	StartPort        int              `json:"start_port"`
+	EndPort          int              `json:"end_port"`
+	OpenPorts        []PortInfo       `json:"open_ports"`
+	ClosedPorts      int              `json:"closed_ports"`
+	ClosedSample     []PortInfo       `json:"closed_sample,omitempty"`
+	TotalPorts       int              `json:"total_ports"`
+	Truncated        bool             `json:"truncated,omitempty"`
+	Shard            string           `json:"shard,omitempty"`
+	ErroredPorts     []int            `json:"errored_ports,omitempty"`
+	HostUp           *bool            `json:"host_up,omitempty"`
+	DownHosts        []string         `json:"down_hosts,omitempty"`
+	ResolvedIPs      []string         `json:"resolved_ips,omitempty"`
+	ScannedIPs       []string         `json:"scanned_ips,omitempty"`
+	SRVEndpoints     []SRVEndpoint    `json:"srv_endpoints,omitempty"`
+	DurationSeconds  float64          `json:"duration_seconds"`
+	Timing           PhaseTiming      `json:"timing"`
+	VerifyFlipped    int              `json:"verify_flipped,omitempty"`
+	Retried          int              `json:"retried,omitempty"`
+	RetryResolved    int              `json:"retry_resolved,omitempty"`
+	FastestPort      *PortTiming      `json:"fastest_port,omitempty"`
+	SlowestPort      *PortTiming      `json:"slowest_port,omitempty"`
+	Violations       []int            `json:"violations,omitempty"`
+	BannerMismatches []int            `json:"banner_mismatches,omitempty"`
+	Categories       map[string][]int `json:"categories,omitempty"`
+	ServiceCounts    map[string]int   `json:"service_counts,omitempty"`
+	Insights         []Insight        `json:"insights,omitempty"`
+	PeakConcurrency  int              `json:"peak_concurrency"`
+	AvgConcurrency   float64          `json:"avg_concurrency"`
+	ScanningSelf     bool             `json:"scanning_self,omitempty"`
+	WildcardDNS      bool             `json:"wildcard_dns,omitempty"`
+	PunycodeTarget   string           `json:"punycode_target,omitempty"`
+	Timestamp        time.Time        `json:"timestamp"`
+	Status           string           `json:"status"`
+	Error            string           `json:"error,omitempty"`
+	Meta             ScanMeta         `json:"meta"`
+	// ConcurrencyCurve is how a slow-start scan changed its concurrency
+	// limit over time (for the first host of a multi-host scan)
+	ConcurrencyCurve []ConcurrencyStep `json:"concurrency_curve,omitempty"`
+	// Cached is set when the web server answered from its result cache
+	// instead of scanning again
+	Cached bool `json:"cached,omitempty"`
+	// ExpiringCerts lists the open ports whose TLS certificate expires
+	// within the requested warning window
+	ExpiringCerts []int `json:"expiring_certs,omitempty"`
+	// IdempotencyKey lets consumers of webhooks and queues drop repeated
+	// deliveries of the same scan: the request's key, or one derived from
+	// its contents
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Deprecated: ResolveMs repeats Timing.ResolveMs, which it is copied
+	// from. It is kept for existing clients and will be removed.
+	ResolveMs float64 `json:"resolve_ms,omitempty"`
+}
+
+// PhaseTiming breaks down where a scan spent its time. ScanMs is the wall
+// time of the connect sweep, including the probes run on open ports;
+// ProbeMs is the time spent in probes summed over every open port, so with
+// concurrent probing it can exceed ScanMs.
+type PhaseTiming struct {
+	ResolveMs float64 `json:"resolve_ms"`
+	ScanMs    float64 `json:"scan_ms"`
+	ProbeMs   float64 `json:"probe_ms"`
+}
+
+// How a scan ended, reported in ScanResponse.Status
+const (
+	// StatusComplete means every planned port was scanned
+	StatusComplete = "complete"
+	// StatusTruncated means -max-dials cut the plan short
+	StatusTruncated = "truncated"
+	// StatusCancelled means the scan was interrupted, e.g. by Ctrl-C or a
+	// client disconnecting, and the results are partial
+	StatusCancelled = "cancelled"
+	// StatusDeadline means the caller's deadline expired mid-scan
+	StatusDeadline = "deadline"
+	// StatusError means the scan failed or aborted; Error has the detail
+	StatusError = "error"
+)
+
+// ScanMeta describes the scanner build that produced a result
+type ScanMeta struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+	// MaxConcurrent is the per-host connection limit the scan ran with,
+	// after resolving per-CPU settings
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// CommandLine is the invocation that produced the result, with secrets
+	// redacted, when -include-cmdline is set
+	CommandLine string `json:"command_line,omitempty"`
 }
 
 // Common well-known ports and services
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ndjsonFile appends each open port to a file as one JSON line the moment it
+// is found, so a scan that crashes still leaves its results on disk. Ports
+// already in the file from an earlier run are not written again, so an
+// interrupted scan can simply be re-run against the same file.
+type ndjsonFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	target string
+	seen   map[string]bool
+	// saved holds the ports an earlier run wrote for the same target
+	saved []PortInfo
+}
+
+// openNDJSONFile opens path for appending and loads the ports it already
+// holds. A partial last line left by a crash is skipped.
+func openNDJSONFile(path, target string) (*ndjsonFile, error) {
+	w := &ndjsonFile{target: target, seen: make(map[string]bool)}
+	existing, err := os.Open(path)
+	switch {
+	case err == nil:
+		lines := bufio.NewScanner(existing)
+		lines.Buffer(nil, 1<<20)
+		for lines.Scan() {
+			var event ResultEvent
+			if json.Unmarshal(lines.Bytes(), &event) == nil {
+				key := ndjsonKey(event.Target, event.Port)
+				if event.Target == target && !w.seen[key] {
+					w.saved = append(w.saved, event.Port)
+				}
+				w.seen[key] = true
+			}
+		}
+		existing.Close()
+		if err := lines.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	w.file, err = os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	// Start on a fresh line if the last write was cut short
+	if info, err := w.file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := w.file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			w.file.Write([]byte{'\n'})
+		}
+	}
+	return w, nil
+}
+
+// ndjsonKey identifies a port across runs
+func ndjsonKey(target string, port PortInfo) string {
+	protocol := port.Protocol
+	if protocol == "" {
+		protocol = protoTCP
+	}
+	return fmt.Sprintf("%s|%s|%d/%s", target, port.IP, port.Port, protocol)
+}
+
+// Write appends one open port and syncs it to disk. Ports the file already
+// holds are skipped.
+func (w *ndjsonFile) Write(port PortInfo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := ndjsonKey(w.target, port)
+	if w.seen[key] {
+		return nil
+	}
+	line, err := json.Marshal(ResultEvent{Target: w.target, Port: port, Timestamp: time.Now()})
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	w.seen[key] = true
+	return w.file.Sync()
+}
+
+// Close closes the file
+func (w *ndjsonFile) Close() error {
+	return w.file.Close()
+}
+
+// scanProgress records each port an -ndjson-file scan finishes in a sidecar
+// file next to it, so re-running an interrupted scan skips those ports and
+// takes their results from the NDJSON file instead
+type scanProgress struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	w    *bufio.Writer
+	// previous holds the ports the earlier run finished, by address and by
+	// port alone for results that carry no address
+	previous      map[string]bool
+	previousPorts map[string]bool
+}
+
+// progressPath is the sidecar file that tracks an NDJSON file's scan
+func progressPath(ndjsonPath string) string {
+	return ndjsonPath + ".progress"
+}
+
+// openScanProgress loads the ports an earlier run of the same target
+// finished and starts a fresh file holding them. A file written for another
+// target is ignored.
+func openScanProgress(path, target string) (*scanProgress, error) {
+	p := &scanProgress{path: path, previous: make(map[string]bool), previousPorts: make(map[string]bool)}
+	header := "# " + target
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		lines := strings.Split(string(data), "\n")
+		if lines[0] == header {
+			// The last element is empty or a line cut short by a crash
+			for _, line := range lines[1 : len(lines)-1] {
+				if _, port, ok := strings.Cut(line, " "); ok {
+					p.previous[line] = true
+					p.previousPorts[port] = true
+				}
+			}
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	p.file, err = os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	p.w = bufio.NewWriter(p.file)
+	fmt.Fprintln(p.w, header)
+	for key := range p.previous {
+		fmt.Fprintln(p.w, key)
+	}
+	return p, p.w.Flush()
+}
+
+// progressKey identifies a port on one address in the progress file
+func progressKey(addr string, port int, protocol string) string {
+	if protocol == "" {
+		protocol = protoTCP
+	}
+	return fmt.Sprintf("%s %d/%s", addr, port, protocol)
+}
+
+// Resumed reports how many ports the earlier run finished
+func (p *scanProgress) Resumed() int {
+	return len(p.previous)
+}
+
+// Skip reports whether the earlier run finished a port. Ports finished in
+// this run are not skipped, so retry and verify passes still see them.
+func (p *scanProgress) Skip(addr string, spec PortSpec) bool {
+	return p.previous[progressKey(addr, spec.Port, spec.Protocol)]
+}
+
+// Done records that a port has its result
+func (p *scanProgress) Done(addr string, spec PortSpec) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.w, progressKey(addr, spec.Port, spec.Protocol))
+}
+
+// Restore adds the open ports the earlier run found on the ports this run
+// skipped to the response, counting them as open and redoing everything
+// worked out from the open ports
+func (p *scanProgress) Restore(response *ScanResponse, saved []PortInfo, req ScanRequest) {
+	restored := 0
+	for _, port := range saved {
+		var skipped bool
+		if port.IP != "" {
+			skipped = p.previous[progressKey(port.IP, port.Port, port.Protocol)]
+		} else {
+			_, key, _ := strings.Cut(progressKey("", port.Port, port.Protocol), " ")
+			skipped = p.previousPorts[key]
+		}
+		if skipped {
+			response.OpenPorts = append(response.OpenPorts, port)
+			restored++
+		}
+	}
+	if restored > 0 {
+		sortResults(response.OpenPorts, req.SortBy)
+		response.ClosedPorts -= restored
+		ApplyOpenPortChecks(response, req)
+	}
+}
+
+// Close flushes the file, removing it once the scan completed so the next
+// run starts over
+func (p *scanProgress) Close(complete bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.w.Flush()
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	if complete {
+		return os.Remove(p.path)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestScanProgressResumes(t *testing.T) {
+	dir := t.TempDir()
+	ndjsonPath := filepath.Join(dir, "results.ndjson")
+	open := testListener(t, listenOpen)
+	closed := testListener(t, listenClosed)
+	ports := portSpecs([]int{open, closed}, nil)
+
+	// An earlier run found the open port, then was interrupted
+	first, err := openNDJSONFile(ndjsonPath, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	progress, err := openScanProgress(progressPath(ndjsonPath), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first.Write(PortInfo{Port: open, Protocol: protoTCP, Service: "saved", ConnectMs: 0.4})
+	progress.Done("127.0.0.1", PortSpec{Port: open, Protocol: protoTCP})
+	first.Close()
+	if err := progress.Close(false); err != nil {
+		t.Fatal(err)
+	}
+
+	ndjson, err := openNDJSONFile(ndjsonPath, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndjson.Close()
+	progress, err = openScanProgress(progressPath(ndjsonPath), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Resumed() != 1 {
+		t.Fatalf("resumed %d ports, want 1", progress.Resumed())
+	}
+
+	var dialed []int
+	result := ScanPorts(context.Background(), "127.0.0.1", ports, ScanOptions{
+		MaxConcurrent: 1,
+		Timeout:       time.Second,
+		Skip:          progress.Skip,
+		OnDone: func(addr string, spec PortSpec) {
+			dialed = append(dialed, spec.Port)
+			progress.Done(addr, spec)
+		},
+	})
+	if len(dialed) != 1 || dialed[0] != closed {
+		t.Fatalf("dialed %v, want only the unfinished port %d", dialed, closed)
+	}
+
+	// Every open port comes from the earlier run, so the fields worked out
+	// from open ports must be redone once they are restored
+	req := ScanRequest{Allowlist: []int{}}
+	response := ScanResponse{OpenPorts: result.OpenPorts, ClosedPorts: len(ports) - result.OpenCount}
+	ApplyOpenPortChecks(&response, req)
+	progress.Restore(&response, ndjson.saved, req)
+	if len(response.OpenPorts) != 1 || response.OpenPorts[0].Service != "saved" {
+		t.Errorf("open ports = %v, want the saved port", response.OpenPorts)
+	}
+	if response.ClosedPorts != 1 {
+		t.Errorf("closed ports = %d, want 1", response.ClosedPorts)
+	}
+	if response.FastestPort == nil || response.FastestPort.Port != open || response.SlowestPort == nil {
+		t.Errorf("fastest port = %v, slowest = %v, want the restored port", response.FastestPort, response.SlowestPort)
+	}
+	if !slices.Equal(response.Violations, []int{open}) {
+		t.Errorf("violations = %v, want the restored port %d", response.Violations, open)
+	}
+	if err := writeTextReport(io.Discard, req, response); err != nil {
+		t.Errorf("text report: %v", err)
+	}
+
+	// A complete scan removes the progress file so the next run starts over
+	if err := progress.Close(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(progressPath(ndjsonPath)); !os.IsNotExist(err) {
+		t.Errorf("progress file still present after a complete scan: %v", err)
+	}
+}
+
+func TestScanProgressIgnoresOtherTarget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.ndjson.progress")
+	if err := os.WriteFile(path, []byte("# other.example\n127.0.0.1 22/tcp\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	progress, err := openScanProgress(path, "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer progress.Close(false)
+	if progress.Skip("127.0.0.1", PortSpec{Port: 22, Protocol: protoTCP}) {
+		t.Error("skipped a port finished while scanning another target")
+	}
+}
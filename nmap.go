@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// nmapOutputs are the files -oA writes for a basename, as nmap does
+func nmapOutputs(basename string) []OutputFile {
+	return []OutputFile{
+		{Format: "nmap", Path: basename + ".nmap"},
+		{Format: "gnmap", Path: basename + ".gnmap"},
+		{Format: "xml", Path: basename + ".xml"},
+	}
+}
+
+// nmapHost is one scanned address with the open ports found on it, in the
+// order nmap-style reports list them
+type nmapHost struct {
+	IP    string
+	Up    bool
+	Ports []PortInfo
+}
+
+// nmapHosts groups the open ports by address. Ports without an address
+// belong to the first scanned address, or the target itself.
+func nmapHosts(resp ScanResponse) []nmapHost {
+	fallback := resp.Target
+	if len(resp.ScannedIPs) > 0 {
+		fallback = resp.ScannedIPs[0]
+	} else if len(resp.ResolvedIPs) > 0 {
+		fallback = resp.ResolvedIPs[0]
+	}
+
+	var hosts []nmapHost
+	index := make(map[string]int)
+	add := func(ip string, up bool) int {
+		i, ok := index[ip]
+		if !ok {
+			i = len(hosts)
+			index[ip] = i
+			hosts = append(hosts, nmapHost{IP: ip, Up: up})
+		}
+		return i
+	}
+	for _, ip := range resp.ScannedIPs {
+		add(ip, !slices.Contains(resp.DownHosts, ip))
+	}
+	for _, port := range resp.OpenPorts {
+		ip := port.IP
+		if ip == "" {
+			ip = fallback
+		}
+		i := add(ip, true)
+		hosts[i].Up = true
+		hosts[i].Ports = append(hosts[i].Ports, port)
+	}
+	for _, ip := range resp.DownHosts {
+		add(ip, false)
+	}
+	if len(hosts) == 0 && resp.Error == "" {
+		add(fallback, resp.HostUp == nil || *resp.HostUp)
+	}
+	return hosts
+}
+
+// nmapHostName is the "name (ip)" label nmap gives a host, or the bare
+// address when the target was given as one
+func nmapHostName(resp ScanResponse, ip string) string {
+	if resp.Target == "" || resp.Target == ip || isTargetList(resp.Target) {
+		return ip
+	}
+	return fmt.Sprintf("%s (%s)", resp.Target, ip)
+}
+
+// isTargetList reports whether a target names several hosts, as a
+// comma-separated list or a CIDR range
+func isTargetList(target string) bool {
+	return strings.ContainsAny(target, ",/")
+}
+
+// singleTarget reports whether the whole result belongs to one address, so
+// its closed port count can be attributed to that host
+func singleTarget(resp ScanResponse, hosts []nmapHost) bool {
+	return len(hosts) == 1 && !isTargetList(resp.Target)
+}
+
+// nmapService is the lowercase service name nmap tooling expects
+func nmapService(port PortInfo) string {
+	if port.Service == "" {
+		return "unknown"
+	}
+	return strings.ToLower(strings.ReplaceAll(port.Service, " ", "-"))
+}
+
+// nmapProtocol is the port's protocol, tcp for results that predate UDP
+func nmapProtocol(port PortInfo) string {
+	if port.Protocol == "" {
+		return protoTCP
+	}
+	return port.Protocol
+}
+
+// nmapSummary is the trailer line shared by the .nmap and .gnmap reports
+func nmapSummary(resp ScanResponse, hosts []nmapHost) string {
+	up := 0
+	for _, host := range hosts {
+		if host.Up {
+			up++
+		}
+	}
+	addresses := "IP addresses"
+	if len(hosts) == 1 {
+		addresses = "IP address"
+	}
+	hostsUp := "hosts up"
+	if up == 1 {
+		hostsUp = "host up"
+	}
+	done := resp.Timestamp.Add(time.Duration(resp.DurationSeconds * float64(time.Second)))
+	return fmt.Sprintf("done at %s -- %d %s (%d %s) scanned in %.2f seconds",
+		done.Format(time.ANSIC), len(hosts), addresses, up, hostsUp, resp.DurationSeconds)
+}
+
+// nmapHeader is the first comment line of the .nmap and .gnmap reports
+func nmapHeader(resp ScanResponse) string {
+	header := fmt.Sprintf("# port-scanner %s scan initiated %s", resp.Meta.Version, resp.Timestamp.Format(time.ANSIC))
+	if resp.Meta.CommandLine != "" {
+		header += " as: " + resp.Meta.CommandLine
+	}
+	return header
+}
+
+// writeGrepableReport renders the results in nmap's grepable (-oG) layout:
+// one Status line and one Ports line per host, fields separated by tabs
+func writeGrepableReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	hosts := nmapHosts(resp)
+	fmt.Fprintln(w, nmapHeader(resp))
+	for _, host := range hosts {
+		name := nmapHostName(resp, host.IP)
+		if !host.Up {
+			fmt.Fprintf(w, "Host: %s\tStatus: Down\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "Host: %s\tStatus: Up\n", name)
+		ports := make([]string, len(host.Ports))
+		for i, port := range host.Ports {
+			ports[i] = fmt.Sprintf("%d/%s/%s//%s///", port.Port, openState(port), nmapProtocol(port), nmapService(port))
+		}
+		line := fmt.Sprintf("Host: %s\tPorts: %s", name, strings.Join(ports, ", "))
+		if resp.ClosedPorts > 0 && singleTarget(resp, hosts) {
+			line += fmt.Sprintf("\tIgnored State: closed (%d)", resp.ClosedPorts)
+		}
+		fmt.Fprintln(w, line)
+	}
+	_, err := fmt.Fprintf(w, "# Scan %s\n", nmapSummary(resp, hosts))
+	return err
+}
+
+// writeNmapReport renders the results as an approximation of nmap's normal
+// (-oN) text output
+func writeNmapReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	hosts := nmapHosts(resp)
+	fmt.Fprintln(w, nmapHeader(resp))
+	if resp.Error != "" {
+		fmt.Fprintf(w, "Scan failed: %s\n", resp.Error)
+	}
+	for _, host := range hosts {
+		fmt.Fprintf(w, "Scan report for %s\n", nmapHostName(resp, host.IP))
+		if !host.Up {
+			fmt.Fprintln(w, "Host seems down.")
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprintln(w, "Host is up.")
+		if resp.ClosedPorts > 0 && singleTarget(resp, hosts) {
+			fmt.Fprintf(w, "Not shown: %d closed ports\n", resp.ClosedPorts)
+		}
+		if len(host.Ports) == 0 {
+			fmt.Fprintln(w, "All scanned ports are closed or filtered")
+			fmt.Fprintln(w)
+			continue
+		}
+		stateWidth := len("STATE")
+		for _, port := range host.Ports {
+			stateWidth = max(stateWidth, len(openState(port)))
+		}
+		fmt.Fprintf(w, "%-9s %-*s %s\n", "PORT", stateWidth, "STATE", "SERVICE")
+		for _, port := range host.Ports {
+			fmt.Fprintf(w, "%-9s %-*s %s\n", fmt.Sprintf("%d/%s", port.Port, nmapProtocol(port)), stateWidth, openState(port), nmapService(port))
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "# Scan %s\n", nmapSummary(resp, hosts))
+	return err
+}
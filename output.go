@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are the helper functions available to output templates
+var templateFuncs = template.FuncMap{
+	"portCount": func(resp ScanResponse) int { return len(resp.OpenPorts) },
+	"portList": func(ports []PortInfo) string {
+		list := make([]string, len(ports))
+		for i, p := range ports {
+			list[i] = strconv.Itoa(p.Port)
+		}
+		return strings.Join(list, ",")
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// ParseOutputTemplate parses a template given inline or loaded from a file.
+// Inline templates may use \n and \t escapes since shells pass them literally.
+func ParseOutputTemplate(text, file string) (*template.Template, error) {
+	if text != "" && file != "" {
+		return nil, fmt.Errorf("-template and -template-file cannot be used together")
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read template file: %v", err)
+		}
+		text = string(data)
+	} else {
+		text = strings.NewReplacer(`\n`, "\n", `\t`, "\t").Replace(text)
+	}
+
+	tmpl, err := template.New("output").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// RenderTemplate renders the scan response through the given template
+func RenderTemplate(tmpl *template.Template, resp ScanResponse) (string, error) {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, resp); err != nil {
+		return "", fmt.Errorf("template execution failed: %v", err)
+	}
+	return sb.String(), nil
+}
+
+// outputWriters maps each -out format to the function that renders it
+var outputWriters = map[string]func(io.Writer, ScanRequest, ScanResponse) error{
+	"text":  writeTextReport,
+	"json":  writeJSONReport,
+	"csv":   writeCSVReport,
+	"xml":   writeXMLReport,
+	"md":    writeMarkdownReport,
+	"nmap":  writeNmapReport,
+	"gnmap": writeGrepableReport,
+	"stix":  writeSTIXReport,
+	"bin":   writeBinaryReport,
+}
+
+// OutputFile is one format:path destination given with -out
+type OutputFile struct {
+	Format string
+	Path   string
+}
+
+// outputFileList collects repeated -out flags
+type outputFileList []OutputFile
+
+// String implements flag.Value
+func (l *outputFileList) String() string {
+	specs := make([]string, len(*l))
+	for i, o := range *l {
+		specs[i] = o.Format + ":" + o.Path
+	}
+	return strings.Join(specs, ",")
+}
+
+// Set implements flag.Value
+func (l *outputFileList) Set(value string) error {
+	format, path, ok := strings.Cut(value, ":")
+	if !ok || path == "" {
+		return fmt.Errorf("expected format:path, got %q", value)
+	}
+	if _, known := outputWriters[format]; !known {
+		return fmt.Errorf("unknown output format %q (use text, json, csv, xml, md, nmap, gnmap, stix or bin)", format)
+	}
+	*l = append(*l, OutputFile{Format: format, Path: path})
+	return nil
+}
+
+// partialSuffix is appended to output paths holding an unfinished scan
+const partialSuffix = ".partial"
+
+// Destination is the file an output is written to: its path, or the path
+// with partialSuffix when the results are from a scan that did not finish
+func (o OutputFile) Destination(partial bool) string {
+	if partial {
+		return o.Path + partialSuffix
+	}
+	return o.Path
+}
+
+// WriteOutputFiles renders the results into every requested file. Each file
+// is replaced atomically, so a consumer watching the directory sees either
+// the previous file or the complete new one. With partial set the results
+// go to the .partial paths instead, leaving the previous files untouched.
+func WriteOutputFiles(outputs []OutputFile, req ScanRequest, resp ScanResponse, partial bool) error {
+	for _, out := range outputs {
+		err := writeFileAtomic(out.Destination(partial), func(w io.Writer) error {
+			return outputWriters[out.Format](w, req, resp)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory that is renamed over path only once write has succeeded, so an
+// interrupted or failed write never leaves a truncated file behind
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	err = write(tmp)
+	if err == nil {
+		// CreateTemp makes the file private; results are meant to be shared
+		err = tmp.Chmod(0o644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// writeTextReport renders the human readable results table
+func writeTextReport(w io.Writer, req ScanRequest, resp ScanResponse) error {
+	fmt.Fprintf(w, "\nScan Results for %s:\n", resp.Target)
+	if len(resp.ResolvedIPs) > 0 {
+		fmt.Fprintf(w, "Resolved to %s in %.1f ms, scanned %s\n",
+			strings.Join(resp.ResolvedIPs, ", "), resp.Timing.ResolveMs, strings.Join(resp.ScannedIPs, ", "))
+	}
+	fmt.Fprintf(w, "Scanned ports %d-%d in %.2f seconds\n",
+		resp.StartPort, resp.EndPort, resp.DurationSeconds)
+	fmt.Fprintf(w, "Found %d open ports out of %d total ports\n",
+		resp.TotalPorts-resp.ClosedPorts, resp.TotalPorts)
+	if resp.Shard != "" {
+		fmt.Fprintf(w, "Shard %s of the port list\n", resp.Shard)
+	}
+	if req.Verify {
+		fmt.Fprintf(w, "Verification pass found %d additional open ports", resp.VerifyFlipped)
+		if resp.VerifyFlipped > 0 {
+			fmt.Fprint(w, " (consider a longer -timeout)")
+		}
+		fmt.Fprintln(w)
+	}
+	// SYN scans send packets rather than holding connections open
+	if resp.PeakConcurrency > 0 {
+		fmt.Fprintf(w, "Concurrency: peak %d, average %.1f (limit %d)\n",
+			resp.PeakConcurrency, resp.AvgConcurrency, resp.Meta.MaxConcurrent)
+	}
+	if resp.Retried > 0 {
+		fmt.Fprintf(w, "Retries: %d of %d timed-out ports answered on retry\n", resp.RetryResolved, resp.Retried)
+	}
+	if resp.Truncated {
+		fmt.Fprintf(w, "Warning: scan truncated to %d connections by -max-dials\n", resp.TotalPorts)
+	}
+	if resp.Status == StatusCancelled || resp.Status == StatusDeadline {
+		fmt.Fprintf(w, "Warning: scan %s before it finished; results are partial\n", resp.Status)
+	}
+	if resp.Error != "" {
+		fmt.Fprintf(w, "Scan error: %s\n", resp.Error)
+	}
+	if len(resp.DownHosts) > 0 {
+		fmt.Fprintf(w, "Warning: stopped scanning %s after %d consecutive timeouts (host down?)\n",
+			strings.Join(resp.DownHosts, ", "), req.DeadHostThreshold)
+	}
+	if len(resp.ErroredPorts) > 0 {
+		fmt.Fprintf(w, "Warning: no result for ports %v (internal error, see log)\n", resp.ErroredPorts)
+	}
+	if resp.ScanningSelf {
+		fmt.Fprintln(w, "Warning: the target is this machine, so results include its own local services")
+	}
+	if resp.WildcardDNS {
+		fmt.Fprintln(w, "Warning: possible wildcard DNS/sinkhole: a random nonexistent name in the target's domain resolves to the same address")
+	}
+	fmt.Fprintln(w)
+
+	// Stream mode has already printed the open ports as they were found
+	if req.StreamOnly {
+		return nil
+	}
+	if len(resp.OpenPorts) > 0 {
+		if resp.Categories != nil {
+			writeCategoryGroups(w, resp)
+		} else {
+			fmt.Fprintln(w, "Open ports:")
+			fmt.Fprintln(w, "PORT     SERVICE")
+			for _, port := range resp.OpenPorts {
+				fmt.Fprintln(w, formatPortLine(port))
+			}
+		}
+		if resp.FastestPort != nil && resp.SlowestPort != nil {
+			fmt.Fprintf(w, "\nFastest: port %d (%.2f ms)  Slowest: port %d (%.2f ms)\n",
+				resp.FastestPort.Port, resp.FastestPort.ConnectMs,
+				resp.SlowestPort.Port, resp.SlowestPort.ConnectMs)
+		}
+	} else {
+		fmt.Fprintln(w, "No open ports found.")
+	}
+
+	if len(resp.ServiceCounts) > 0 {
+		fmt.Fprintf(w, "\nServices: %s\n", formatServiceCounts(resp.ServiceCounts))
+	}
+
+	if resp.Insights != nil {
+		writeInsights(w, resp)
+	}
+
+	if len(resp.SRVEndpoints) > 0 {
+		fmt.Fprintln(w, "\nSRV endpoints:")
+		for _, e := range resp.SRVEndpoints {
+			endpoint := net.JoinHostPort(e.Target, strconv.Itoa(e.Port))
+			if e.Address != "" {
+				endpoint += " (" + e.Address + ")"
+			}
+			fmt.Fprintf(w, "%-40s %-10s priority %d, weight %d\n", endpoint, e.State, e.Priority, e.Weight)
+		}
+	}
+
+	if len(resp.ClosedSample) > 0 {
+		fmt.Fprintln(w, "\nSample of closed ports:")
+		for _, port := range resp.ClosedSample {
+			fmt.Fprintf(w, "%-8s %s\n", portLabel(port), port.State)
+		}
+	}
+	return nil
+}
+
+// writeInsights prints the open-port density and the security notes for
+// the open ports
+func writeInsights(w io.Writer, resp ScanResponse) {
+	fmt.Fprintln(w, "\nInsights:")
+	if resp.TotalPorts > 0 {
+		fmt.Fprintf(w, "  %d of %d ports open (%.2f%%)\n",
+			len(resp.OpenPorts), resp.TotalPorts, float64(len(resp.OpenPorts))*100/float64(resp.TotalPorts))
+	}
+	if len(resp.Insights) == 0 {
+		fmt.Fprintln(w, "  No security notes for the open ports")
+		return
+	}
+	for _, insight := range resp.Insights {
+		label := portLabel(PortInfo{Port: insight.Port, Protocol: insight.Protocol})
+		if insight.Service != "" && insight.Service != "unknown" {
+			label += " (" + insight.Service + ")"
+		}
+		fmt.Fprintf(w, "  Port %s is open: %s\n", label, insight.Advisory)
+	}
+}
+
+// portLabel is the port number as shown in text output, with a /udp suffix
+// for UDP ports
+func portLabel(port PortInfo) string {
+	if port.Protocol == protoUDP {
+		return strconv.Itoa(port.Port) + "/udp"
+	}
+	return strconv.Itoa(port.Port)
+}
+
+// writeCategoryGroups lists the open ports under a heading per category
+func writeCategoryGroups(w io.Writer, resp ScanResponse) {
+	first := true
+	for _, category := range categoryOrder {
+		if len(resp.Categories[category]) == 0 {
+			continue
+		}
+		// Blank lines go only between groups that are printed
+		if !first {
+			fmt.Fprintln(w)
+		}
+		first = false
+		fmt.Fprintf(w, "%s (%d):\n", category, len(resp.Categories[category]))
+		for _, port := range resp.OpenPorts {
+			if CategoryOf(port.Port) == category {
+				fmt.Fprintln(w, "  "+formatPortLine(port))
+			}
+		}
+	}
+}
+
+// writeJSONReport renders the full response as indented JSON
+func writeJSONReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	data, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// writeCSVReport renders one row per open port
+func writeCSVReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"target", "port", "ip", "service", "state", "connect_ms", "protocol"})
+	for _, p := range resp.OpenPorts {
+		cw.Write([]string{
+			resp.Target,
+			strconv.Itoa(p.Port),
+			p.IP,
+			p.Service,
+			p.State,
+			strconv.FormatFloat(p.ConnectMs, 'f', 2, 64),
+			p.Protocol,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// FormatPortList renders the open port numbers, sorted and without
+// duplicates, one per line
+func FormatPortList(resp ScanResponse) string {
+	ports := make([]int, 0, len(resp.OpenPorts))
+	for _, p := range resp.OpenPorts {
+		ports = append(ports, p.Port)
+	}
+	slices.Sort(ports)
+	var b strings.Builder
+	for _, port := range slices.Compact(ports) {
+		b.WriteString(strconv.Itoa(port))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// FormatMarkdown renders the open ports as a GitHub-flavored Markdown table
+// under a one-line summary. A banner column is added when any port has one.
+func FormatMarkdown(resp ScanResponse) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "**%s**: %d open of %d ports scanned in %.2fs",
+		markdownCell(resp.Target), resp.TotalPorts-resp.ClosedPorts, resp.TotalPorts, resp.DurationSeconds)
+	if resp.Error != "" {
+		fmt.Fprintf(&sb, " (error: %s)", markdownCell(resp.Error))
+	}
+	sb.WriteString("\n\n")
+	if len(resp.OpenPorts) == 0 {
+		sb.WriteString("No open ports found.\n")
+		return sb.String()
+	}
+
+	banners := slices.ContainsFunc(resp.OpenPorts, func(p PortInfo) bool { return p.Banner != "" })
+	if banners {
+		sb.WriteString("| Port | Service | State | Banner |\n|---:|---|---|---|\n")
+	} else {
+		sb.WriteString("| Port | Service | State |\n|---:|---|---|\n")
+	}
+	for _, p := range resp.OpenPorts {
+		fmt.Fprintf(&sb, "| %s | %s | %s |", portLabel(p), markdownCell(p.Service), markdownCell(p.State))
+		if banners {
+			fmt.Fprintf(&sb, " %s |", markdownCell(firstLine(p.Banner)))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// markdownCell escapes text so it stays inside one table cell
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// writeMarkdownReport renders the results as a Markdown table
+func writeMarkdownReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	_, err := io.WriteString(w, FormatMarkdown(resp))
+	return err
+}
+
+// xmlReport is the XML document layout for scan results
+type xmlReport struct {
+	XMLName         xml.Name  `xml:"scan"`
+	Target          string    `xml:"target,attr"`
+	StartPort       int       `xml:"start_port,attr"`
+	EndPort         int       `xml:"end_port,attr"`
+	TotalPorts      int       `xml:"total_ports,attr"`
+	ClosedPorts     int       `xml:"closed_ports,attr"`
+	DurationSeconds float64   `xml:"duration_seconds,attr"`
+	Timestamp       string    `xml:"timestamp,attr"`
+	Error           string    `xml:"error,omitempty"`
+	Ports           []xmlPort `xml:"port"`
+}
+
+// xmlPort is one open port in an xmlReport
+type xmlPort struct {
+	Port      int     `xml:"number,attr"`
+	Protocol  string  `xml:"protocol,attr,omitempty"`
+	IP        string  `xml:"ip,attr,omitempty"`
+	Service   string  `xml:"service,attr"`
+	State     string  `xml:"state,attr"`
+	ConnectMs float64 `xml:"connect_ms,attr"`
+}
+
+// writeXMLReport renders the results as XML
+func writeXMLReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	doc := xmlReport{
+		Target:          resp.Target,
+		StartPort:       resp.StartPort,
+		EndPort:         resp.EndPort,
+		TotalPorts:      resp.TotalPorts,
+		ClosedPorts:     resp.ClosedPorts,
+		DurationSeconds: resp.DurationSeconds,
+		Timestamp:       resp.Timestamp.Format(time.RFC3339),
+		Error:           resp.Error,
+	}
+	for _, p := range resp.OpenPorts {
+		doc.Ports = append(doc.Ports, xmlPort{
+			Port:      p.Port,
+			Protocol:  p.Protocol,
+			IP:        p.IP,
+			Service:   p.Service,
+			State:     p.State,
+			ConnectMs: p.ConnectMs,
+		})
+	}
+
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteCategoryGroupsSeparators(t *testing.T) {
+	// No web ports, so the first group printed is not the first category
+	ports := []PortInfo{{Port: 3306, State: "open"}, {Port: 22, State: "open"}}
+	resp := ScanResponse{OpenPorts: ports, Categories: GroupByCategory(ports)}
+
+	var out strings.Builder
+	writeCategoryGroups(&out, resp)
+	text := out.String()
+	if strings.HasPrefix(text, "\n") {
+		t.Errorf("output starts with a blank line:\n%s", text)
+	}
+	if got := strings.Count(text, "\n\n"); got != 1 {
+		t.Errorf("got %d blank lines between 2 groups, want 1:\n%s", got, text)
+	}
+}
+
+func TestWriteTextReportWithoutConnectTimes(t *testing.T) {
+	// A response built outside RunScanContext may have no fastest/slowest
+	resp := ScanResponse{Target: "127.0.0.1", OpenPorts: []PortInfo{{Port: 22, State: "open"}}}
+	var out strings.Builder
+	if err := writeTextReport(&out, ScanRequest{}, resp); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "Fastest:") {
+		t.Errorf("printed connect times without any:\n%s", out.String())
+	}
+}
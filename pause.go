@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// pauseGate lets a running scan be paused and resumed. While it is paused
+// no new dials are dispatched; dials already in flight finish normally, so
+// a resumed scan carries on from the next port. A nil pauseGate is never
+// paused.
+type pauseGate struct {
+	mu     sync.Mutex
+	paused bool
+	// resumed is closed when a pause ends
+	resumed chan struct{}
+}
+
+// Pause stops new dials until Resume is called
+func (g *pauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused {
+		g.paused = true
+		g.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets a paused scan continue
+func (g *pauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.paused {
+		g.paused = false
+		close(g.resumed)
+	}
+}
+
+// Paused reports whether the scan is paused
+func (g *pauseGate) Paused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// wait blocks while the scan is paused or until ctx is done
+func (g *pauseGate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	paused, resumed := g.paused, g.resumed
+	g.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runningScans tracks the web scans in progress so they can be paused and
+// resumed by ID
+type runningScans struct {
+	mu     sync.Mutex
+	nextID int
+	gates  map[string]*pauseGate
+}
+
+// newRunningScans creates an empty registry
+func newRunningScans() *runningScans {
+	return &runningScans{gates: make(map[string]*pauseGate)}
+}
+
+// Add registers a new scan and returns its ID and pause gate
+func (s *runningScans) Add() (string, *pauseGate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	gate := &pauseGate{}
+	s.gates[id] = gate
+	return id, gate
+}
+
+// Remove forgets a finished scan
+func (s *runningScans) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.gates, id)
+}
+
+// pauseState is the reply to a pause or resume request
+type pauseState struct {
+	ID     string `json:"id"`
+	Paused bool   `json:"paused"`
+}
+
+// handlePause serves POST /scan/{id}/pause and POST /scan/{id}/resume
+func (s *runningScans) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/scan/"), "/")
+	if !ok || (action != "pause" && action != "resume") {
+		http.NotFound(w, r)
+		return
+	}
+	s.mu.Lock()
+	gate := s.gates[id]
+	s.mu.Unlock()
+	if gate == nil {
+		http.Error(w, "Scan not found or already finished", http.StatusNotFound)
+		return
+	}
+	if action == "pause" {
+		gate.Pause()
+	} else {
+		gate.Resume()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pauseState{ID: id, Paused: gate.Paused()})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ScanPolicy restricts which addresses may be scanned. An address inside a
+// Deny prefix is always refused; when Allow is non-empty, an address must
+// also be inside one of its prefixes. The zero policy permits everything.
+type ScanPolicy struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+	// DenyPrivate refuses private and link-local addresses. Loopback is
+	// still permitted so the server can be tried against itself.
+	DenyPrivate bool
+}
+
+// empty reports whether the policy permits everything
+func (p ScanPolicy) empty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0 && !p.DenyPrivate
+}
+
+// scanPolicy is enforced on every resolved target before it is dialed. The
+// web server sets it from -allow-cidr and -deny-cidr.
+var scanPolicy ScanPolicy
+
+// Permits reports whether an address may be scanned, or why not
+func (p ScanPolicy) Permits(addr string) error {
+	if p.empty() {
+		return nil
+	}
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return fmt.Errorf("target %s is not an IP address", addr)
+	}
+	ip = ip.Unmap()
+	for _, prefix := range p.Deny {
+		if prefix.Contains(ip) {
+			return fmt.Errorf("target %s is in denied range %s", addr, prefix)
+		}
+	}
+	if p.DenyPrivate && (ip.IsPrivate() || ip.IsLinkLocalUnicast()) {
+		return fmt.Errorf("target %s is a private address, which this server does not scan", addr)
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, prefix := range p.Allow {
+		if prefix.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("target %s is outside the allowed ranges", addr)
+}
+
+// CheckScanPolicy resolves every target of a request and checks each
+// address against the policy, so a disallowed scan is refused with a clear
+// error before it starts. RunScanContext checks the addresses it actually
+// dials again, which also catches names that resolve differently later.
+func CheckScanPolicy(ctx context.Context, req ScanRequest, policy ScanPolicy) error {
+	if policy.empty() {
+		return nil
+	}
+	if req.SRV != "" {
+		endpoints, err := ResolveSRV(ctx, req.SRV)
+		if err != nil {
+			return err
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.Address == "" {
+				continue
+			}
+			if err := policy.Permits(endpoint.Address); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	hosts, err := ExpandTargets([]string{req.Host})
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		addrs := []string{h.Host}
+		if net.ParseIP(h.Host) == nil {
+			if addrs, err = lookupHost(ctx, h.Host); err != nil {
+				return fmt.Errorf("failed to resolve hostname %s: %v", h.Host, err)
+			}
+		}
+		for _, addr := range addrs {
+			if err := policy.Permits(addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cidrListFlag collects repeatable CIDR flags such as -allow-cidr
+type cidrListFlag []netip.Prefix
+
+// String implements flag.Value
+func (l *cidrListFlag) String() string {
+	prefixes := make([]string, len(*l))
+	for i, prefix := range *l {
+		prefixes[i] = prefix.String()
+	}
+	return strings.Join(prefixes, ",")
+}
+
+// Set implements flag.Value. A bare address is taken as a single host.
+func (l *cidrListFlag) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %q", entry)
+			}
+			*l = append(*l, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", entry)
+		}
+		*l = append(*l, netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()).Masked())
+	}
+	return nil
+}
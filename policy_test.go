@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestScanPolicyDenyPrivate(t *testing.T) {
+	policy := ScanPolicy{DenyPrivate: true}
+	for addr, permitted := range map[string]bool{
+		"127.0.0.1":   true,
+		"::1":         true,
+		"192.0.2.1":   true,
+		"10.1.2.3":    false,
+		"192.168.0.1": false,
+		"169.254.0.1": false,
+		"fd00::1":     false,
+		"fe80::1":     false,
+	} {
+		if err := policy.Permits(addr); (err == nil) != permitted {
+			t.Errorf("Permits(%s) = %v, want permitted %v", addr, err, permitted)
+		}
+	}
+}
+
+func TestLookupHostLocalhost(t *testing.T) {
+	// A resolver that cannot answer shows no lookup is made
+	saved := resolver
+	t.Cleanup(func() { resolver = saved })
+	if err := UseDNSServer("127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{"localhost", "LOCALHOST.", "app.localhost"} {
+		addrs, err := lookupHost(context.Background(), host)
+		if err != nil || !slices.Equal(addrs, []string{"127.0.0.1", "::1"}) {
+			t.Errorf("lookupHost(%s) = %v, %v, want the loopback addresses", host, addrs, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResolvePorts returns the TCP ports a request should scan. The selection
+// is layered in a fixed order: the base set (the explicit list, otherwise
+// the start to end range) is narrowed to well-known ports by KnownOnly,
+// AlsoPorts are added, and ExcludePorts are removed last, so an exclusion
+// always wins.
+func ResolvePorts(req ScanRequest) []int {
+	var base []int
+	switch {
+	case len(req.Ports) > 0:
+		base = req.Ports
+	case len(req.UDPPorts) == 0:
+		// A UDP-only port list scans no TCP ports rather than the default range
+		base = PortRange(req.StartPort, req.EndPort)
+	}
+
+	excluded := make(map[int]bool, len(req.ExcludePorts))
+	for _, p := range req.ExcludePorts {
+		excluded[p] = true
+	}
+	seen := make(map[int]bool, len(base)+len(req.AlsoPorts))
+	ports := make([]int, 0, len(base)+len(req.AlsoPorts))
+	add := func(p int) {
+		if !seen[p] && !excluded[p] {
+			seen[p] = true
+			ports = append(ports, p)
+		}
+	}
+	for _, p := range base {
+		if _, known := CommonPorts[p]; known || !req.KnownOnly {
+			add(p)
+		}
+	}
+	for _, p := range req.AlsoPorts {
+		add(p)
+	}
+	return ports
+}
+
+// ParseShard parses a shard such as "2/5", meaning the second of five
+func ParseShard(spec string) (int, int, error) {
+	indexText, countText, ok := strings.Cut(spec, "/")
+	index, err1 := strconv.Atoi(strings.TrimSpace(indexText))
+	count, err2 := strconv.Atoi(strings.TrimSpace(countText))
+	if !ok || err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid shard %q (expected index/count, e.g. 2/5)", spec)
+	}
+	if count < 1 || index < 1 || index > count {
+		return 0, 0, fmt.Errorf("invalid shard %q: index must be between 1 and the shard count", spec)
+	}
+	return index, count, nil
+}
+
+// shardPorts keeps every count-th entry of the port list, starting at
+// position index-1. Shards of the same list never overlap, together cover
+// it, and differ in size by at most one port.
+func shardPorts(ports []PortSpec, index, count int) []PortSpec {
+	if count <= 1 {
+		return ports
+	}
+	shard := make([]PortSpec, 0, len(ports)/count+1)
+	for i := index - 1; i < len(ports); i += count {
+		shard = append(shard, ports[i])
+	}
+	return shard
+}
+
+// ParsePortList parses a TCP port list such as "8000,9000-9010" for the
+// -also and -exclude flags
+func ParsePortList(spec string) ([]int, error) {
+	specs, err := ParsePortSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	ports := make([]int, 0, len(specs))
+	for _, s := range specs {
+		if s.Protocol == protoUDP {
+			return nil, fmt.Errorf("%q: only TCP ports are supported here", spec)
+		}
+		ports = append(ports, s.Port)
+	}
+	return ports, nil
+}
+
+// ParsePortSpec parses an nmap-style port list such as "T:22,80 U:53,161".
+// Entries are ports or start-end ranges separated by commas or spaces. A T:
+// or U: prefix selects TCP or UDP for that entry and the ones after it;
+// entries before any prefix are TCP.
+func ParsePortSpec(spec string) ([]PortSpec, error) {
+	var ports []PortSpec
+	protocol := protoTCP
+	for _, field := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		switch {
+		case strings.HasPrefix(strings.ToUpper(field), "T:"):
+			protocol, field = protoTCP, field[2:]
+		case strings.HasPrefix(strings.ToUpper(field), "U:"):
+			protocol, field = protoUDP, field[2:]
+		}
+		if field == "" {
+			continue
+		}
+		start, end := field, field
+		if strings.Contains(field, "-") {
+			start, end, _ = strings.Cut(field, "-")
+		}
+		first, err1 := strconv.Atoi(start)
+		last, err2 := strconv.Atoi(end)
+		if err1 != nil || err2 != nil || first < 1 || last > 65535 || first > last {
+			return nil, fmt.Errorf("invalid port %q in %q", field, spec)
+		}
+		for port := first; port <= last; port++ {
+			ports = append(ports, PortSpec{Port: port, Protocol: protocol})
+		}
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports in %q", spec)
+	}
+	return ports, nil
+}
+
+// LoadPortFile reads one port per line, ignoring blank lines and # comments.
+// Every invalid line is reported with its line number.
+func LoadPortFile(path string) ([]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ports []int
+	var bad []error
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		port, err := strconv.Atoi(line)
+		if err != nil || port < 1 || port > 65535 {
+			bad = append(bad, fmt.Errorf("%s:%d: invalid port %q", path, lineNum, line))
+			continue
+		}
+		ports = append(ports, port)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(bad) > 0 {
+		return nil, errors.Join(bad...)
+	}
+	return ports, nil
+}
+
+// ApplyAllowlist flags open ports that are not in the allowlist and records
+// them as violations on the response
+func ApplyAllowlist(resp *ScanResponse, allowlist []int) {
+	allowed := make(map[int]bool, len(allowlist))
+	for _, p := range allowlist {
+		allowed[p] = true
+	}
+
+	resp.Violations = nil
+	for i := range resp.OpenPorts {
+		if !allowed[resp.OpenPorts[i].Port] {
+			resp.OpenPorts[i].Unexpected = true
+			resp.Violations = append(resp.Violations, resp.OpenPorts[i].Port)
+		}
+	}
+	sort.Ints(resp.Violations)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// TestResolvePortsLayers checks the selection layers against the truth
+// table in the README: base set, then -known-only, then -also, then
+// -exclude. In the base range 20-26, ports 20-23 and 25 are well-known and
+// 24 and 26 are not; 8000 and 9000 are not well-known either.
+func TestResolvePortsLayers(t *testing.T) {
+	tests := []struct {
+		name string
+		req  ScanRequest
+		want []int
+	}{
+		{
+			name: "base only",
+			req:  ScanRequest{StartPort: 20, EndPort: 26},
+			want: []int{20, 21, 22, 23, 24, 25, 26},
+		},
+		{
+			name: "known-only drops unknown base ports",
+			req:  ScanRequest{StartPort: 20, EndPort: 26, KnownOnly: true},
+			want: []int{20, 21, 22, 23, 25},
+		},
+		{
+			name: "also adds to the base",
+			req:  ScanRequest{StartPort: 20, EndPort: 22, AlsoPorts: []int{8000}},
+			want: []int{20, 21, 22, 8000},
+		},
+		{
+			name: "also keeps unknown ports under known-only",
+			req:  ScanRequest{StartPort: 20, EndPort: 26, KnownOnly: true, AlsoPorts: []int{24, 9000}},
+			want: []int{20, 21, 22, 23, 25, 24, 9000},
+		},
+		{
+			name: "also does not duplicate base ports",
+			req:  ScanRequest{StartPort: 20, EndPort: 22, AlsoPorts: []int{22, 8000, 8000}},
+			want: []int{20, 21, 22, 8000},
+		},
+		{
+			name: "exclude removes base ports",
+			req:  ScanRequest{StartPort: 20, EndPort: 26, ExcludePorts: []int{22, 24}},
+			want: []int{20, 21, 23, 25, 26},
+		},
+		{
+			name: "exclude wins over also",
+			req:  ScanRequest{StartPort: 20, EndPort: 22, AlsoPorts: []int{8000, 9000}, ExcludePorts: []int{9000}},
+			want: []int{20, 21, 22, 8000},
+		},
+		{
+			name: "all layers",
+			req:  ScanRequest{StartPort: 20, EndPort: 26, KnownOnly: true, AlsoPorts: []int{8000, 9000}, ExcludePorts: []int{25}},
+			want: []int{20, 21, 22, 23, 8000, 9000},
+		},
+		{
+			name: "explicit list replaces the range",
+			req:  ScanRequest{StartPort: 1, EndPort: 1024, Ports: []int{22, 24, 80}, KnownOnly: true},
+			want: []int{22, 80},
+		},
+		{
+			name: "UDP-only list scans no TCP ports",
+			req:  ScanRequest{StartPort: 1, EndPort: 1024, UDPPorts: []int{53}, AlsoPorts: []int{8000}},
+			want: []int{8000},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolvePorts(tt.req); !slices.Equal(got, tt.want) {
+				t.Errorf("ResolvePorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultProbeTimeout bounds each probe, including any redirect it follows
+const defaultProbeTimeout = 3 * time.Second
+
+// newQUICProber builds the QUIC prober. It is nil unless the binary was
+// built with -tags quic, which pulls in the QUIC implementation.
+var newQUICProber func(serverName string, alpn []string, timeout time.Duration) Prober
+
+// Prober inspects an open port and records what it finds on the PortInfo
+type Prober interface {
+	Probe(ctx context.Context, host string, info *PortInfo)
+}
+
+// probersFor builds the probers enabled by a scan request
+func probersFor(req ScanRequest) []Prober {
+	var probers []Prober
+	// A virtual host is sent as the TLS server name and the HTTP Host
+	// header, so the probes reach it even when the port is dialed by IP
+	serverName := cmp.Or(req.VirtualHost, req.TLSServerName)
+	headers := req.HTTPHeaders
+	if req.VirtualHost != "" {
+		headers = maps.Clone(headers)
+		if headers == nil {
+			headers = make(map[string]string, 1)
+		}
+		headers["Host"] = req.VirtualHost
+	}
+	if req.GrabBanner || len(req.ExpectBanner) > 0 {
+		// Patterns were checked by ValidateScanRequest
+		expect, _ := compileBannerExpectations(req.ExpectBanner)
+		probers = append(probers, bannerProber{timeout: defaultBannerTimeout, expect: expect})
+	}
+	if req.MeasureTTFB {
+		probers = append(probers, ttfbProber{timeout: defaultBannerTimeout})
+	}
+	if req.DetectResets {
+		probers = append(probers, resetProber{timeout: defaultProbeTimeout})
+	}
+	if req.HTTPProbe {
+		probers = append(probers, httpProber{
+			followRedirects: req.FollowRedirects,
+			timeout:         defaultProbeTimeout,
+			serverName:      serverName,
+			method:          cmp.Or(strings.ToUpper(req.HTTPMethod), http.MethodHead),
+			path:            cmp.Or(req.HTTPPath, "/"),
+			headers:         headers,
+		})
+	}
+	if req.TLSProbe {
+		probers = append(probers, tlsProber{
+			serverName:  serverName,
+			virtualHost: req.VirtualHost,
+			alpn:        req.TLSALPN,
+			timeout:     defaultProbeTimeout,
+			warnWithin:  time.Duration(req.CertExpiryWarnDays) * 24 * time.Hour,
+		})
+	}
+	if req.QUICProbe && newQUICProber != nil {
+		probers = append(probers, newQUICProber(serverName, req.TLSALPN, defaultProbeTimeout))
+	}
+	return probers
+}
+
+// validateHTTPProbe checks the request line and headers of the HTTP probe
+func validateHTTPProbe(method, path string, headers map[string]string) error {
+	if method != "" && !httpProbeMethods[strings.ToUpper(method)] {
+		return fmt.Errorf("HTTP probe method %q is not allowed (use GET, HEAD or OPTIONS)", method)
+	}
+	if path != "" {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("HTTP probe path %q must start with /", path)
+		}
+		if strings.ContainsFunc(path, isHTTPUnsafe) || strings.Contains(path, " ") {
+			return fmt.Errorf("HTTP probe path %q contains spaces or control characters", path)
+		}
+	}
+	for name, value := range headers {
+		if name == "" || strings.ContainsAny(name, " :\t") || strings.ContainsFunc(name, isHTTPUnsafe) {
+			return fmt.Errorf("invalid HTTP probe header name %q", name)
+		}
+		if strings.ContainsFunc(value, isHTTPUnsafe) {
+			return fmt.Errorf("HTTP probe header %s contains control characters", name)
+		}
+	}
+	return nil
+}
+
+// validateVirtualHost checks that a virtual host is a bare hostname that
+// some probe will send, and that it does not contradict an explicit TLS
+// server name or Host header
+func validateVirtualHost(req ScanRequest) error {
+	vhost := req.VirtualHost
+	if net.ParseIP(vhost) != nil || strings.ContainsAny(vhost, ":/ ") || strings.ContainsFunc(vhost, isHTTPUnsafe) {
+		return fmt.Errorf("virtual host %q must be a hostname without a port", vhost)
+	}
+	if !req.HTTPProbe && !req.TLSProbe && !req.QUICProbe {
+		return errors.New("virtual host needs the HTTP, TLS or QUIC probe")
+	}
+	if req.TLSServerName != "" && !strings.EqualFold(req.TLSServerName, vhost) {
+		return errors.New("virtual host and TLS server name disagree; set only the virtual host")
+	}
+	for name, value := range req.HTTPHeaders {
+		if strings.EqualFold(name, "Host") && !strings.EqualFold(value, vhost) {
+			return errors.New("virtual host and HTTP Host header disagree; set only the virtual host")
+		}
+	}
+	return nil
+}
+
+// isHTTPUnsafe reports control characters that could split a request
+func isHTTPUnsafe(r rune) bool {
+	return r < 0x20 && r != '\t' || r == 0x7f
+}
+
+// httpHeaderFlag collects repeated -http-header "Name: value" flags
+type httpHeaderFlag map[string]string
+
+// String implements flag.Value
+func (f httpHeaderFlag) String() string {
+	headers := make([]string, 0, len(f))
+	for name, value := range f {
+		headers = append(headers, name+": "+value)
+	}
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+// Set implements flag.Value
+func (f httpHeaderFlag) Set(value string) error {
+	name, headerValue, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected \"Name: value\", got %q", value)
+	}
+	f[strings.TrimSpace(name)] = strings.TrimSpace(headerValue)
+	return nil
+}
+
+// TTFB outcomes of services that sent nothing, reported in PortInfo.TTFBMs
+const (
+	// ttfbSilent is a service that kept the connection open, waiting for
+	// the client to speak
+	ttfbSilent = -1
+	// ttfbClosed is a service that hung up without sending anything
+	ttfbClosed = -2
+)
+
+// ttfbProber measures how long a service takes to send its first byte
+// without being sent anything. Services that speak first (SSH, FTP, SMTP)
+// answer quickly; silent ones (HTTP) wait for a request and get ttfbSilent,
+// and ones that hang up straight away get ttfbClosed.
+type ttfbProber struct {
+	timeout time.Duration
+}
+
+// Probe implements Prober
+func (p ttfbProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	dialCtx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, "tcp", net.JoinHostPort(host, strconv.Itoa(info.Port)))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	// The wait for the first byte gets the whole timeout, however long the
+	// dial took
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(p.timeout))
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	n, err := conn.Read(make([]byte, 1))
+	switch {
+	case n > 0:
+		info.TTFBMs = float64(time.Since(start).Microseconds()) / 1000
+	case errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET):
+		info.TTFBMs = ttfbClosed
+	default:
+		info.TTFBMs = ttfbSilent
+	}
+}
+
+// resetWindow is how soon after accepting a connection a service must close
+// it, without sending anything, for the reset prober to flag it
+const resetWindow = 200 * time.Millisecond
+
+// resetProber reconnects to an open port and waits briefly for data. A real
+// service either speaks first or waits for the client; one that closes or
+// resets the connection straight away with nothing said is likely a tarpit
+// or honeypot accepting everything, and the port is flagged as suspicious.
+type resetProber struct {
+	timeout time.Duration
+}
+
+// Probe implements Prober
+func (p resetProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(info.Port)))
+	if err != nil {
+		// A reset can beat the dial's own completion check
+		info.Suspicious = errors.Is(err, syscall.ECONNRESET)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(resetWindow))
+
+	n, err := conn.Read(make([]byte, 1))
+	if n > 0 || err == nil {
+		return
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		// Still open and silent: a service waiting for the client
+		return
+	}
+	info.Suspicious = errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// httpProbeMethods are the request methods the HTTP probe may send. Only
+// methods that should not change anything on the server are allowed.
+var httpProbeMethods = map[string]bool{http.MethodHead: true, http.MethodGet: true, http.MethodOptions: true}
+
+// httpProber sends a request (HEAD / by default) and records the status and
+// server header
+type httpProber struct {
+	followRedirects bool
+	timeout         time.Duration
+	serverName      string
+	method          string
+	path            string
+	headers         map[string]string
+}
+
+// Probe implements Prober
+func (p httpProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: p.serverName},
+			DisableKeepAlives: true,
+		},
+		// Follow at most one hop so redirect loops cannot stall the probe
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !p.followRedirects || len(via) > 1 {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+
+	target := httpScheme(info.Port) + "://" + net.JoinHostPort(host, strconv.Itoa(info.Port)) + p.path
+	req, err := http.NewRequestWithContext(ctx, p.method, target, nil)
+	if err != nil {
+		return
+	}
+	for name, value := range p.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			info.VirtualHost = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+
+	info.HTTPStatus = resp.StatusCode
+	info.HTTPServer = resp.Header.Get("Server")
+	if final := resp.Request.URL.String(); final != target {
+		info.HTTPRedirect = final
+	} else if location, err := resp.Location(); err == nil {
+		info.HTTPRedirect = location.String()
+	}
+}
+
+// tlsProber performs a TLS handshake and records the negotiated protocol and
+// the certificate that was presented. With warnWithin set, a certificate
+// expiring sooner than that is flagged. virtualHost is recorded on the port
+// only when one was requested; a plain server name override is not a vhost.
+type tlsProber struct {
+	serverName  string
+	virtualHost string
+	alpn        []string
+	timeout     time.Duration
+	warnWithin  time.Duration
+}
+
+// Probe implements Prober
+func (p tlsProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	dialer := &tls.Dialer{Config: &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         p.serverName,
+		NextProtos:         p.alpn,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(info.Port)))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	info.TLS = true
+	if p.virtualHost != "" {
+		info.VirtualHost = p.virtualHost
+	}
+	info.ALPN = state.NegotiatedProtocol
+	if len(state.PeerCertificates) > 0 {
+		leaf := state.PeerCertificates[0]
+		info.TLSSubject = leaf.Subject.CommonName
+		notAfter := leaf.NotAfter
+		info.CertNotAfter = &notAfter
+		info.CertExpiringSoon = p.warnWithin > 0 && time.Until(leaf.NotAfter) < p.warnWithin
+	}
+}
+
+// ApplyCertExpiry records the open ports whose certificate was flagged as
+// expiring soon by the TLS probe
+func ApplyCertExpiry(resp *ScanResponse) {
+	resp.ExpiringCerts = nil
+	for _, port := range resp.OpenPorts {
+		if port.CertExpiringSoon {
+			resp.ExpiringCerts = append(resp.ExpiringCerts, port.Port)
+		}
+	}
+	slices.Sort(resp.ExpiringCerts)
+	resp.ExpiringCerts = slices.Compact(resp.ExpiringCerts)
+}
+
+// httpScheme guesses whether a port speaks HTTP or HTTPS
+func httpScheme(port int) string {
+	switch port {
+	case 443, 8443:
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,53 @@
+//go:build quic
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// defaultQUICALPN is offered when no -tls-alpn list is given
+var defaultQUICALPN = []string{"h3"}
+
+func init() {
+	newQUICProber = func(serverName string, alpn []string, timeout time.Duration) Prober {
+		if len(alpn) == 0 {
+			alpn = defaultQUICALPN
+		}
+		return quicProber{serverName: serverName, alpn: alpn, timeout: timeout}
+	}
+}
+
+// quicProber attempts a QUIC handshake on the UDP port with the same number
+// as an open TCP port, which is where HTTP/3 is served alongside HTTPS
+type quicProber struct {
+	serverName string
+	alpn       []string
+	timeout    time.Duration
+}
+
+// Probe implements Prober
+func (p quicProber) Probe(ctx context.Context, host string, info *PortInfo) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         p.serverName,
+		NextProtos:         p.alpn,
+	}
+	conn, err := quic.DialAddr(ctx, net.JoinHostPort(host, strconv.Itoa(info.Port)), tlsConf, nil)
+	if err != nil {
+		return
+	}
+	defer conn.CloseWithError(0, "")
+
+	info.QUIC = true
+	info.QUICALPN = conn.ConnectionState().TLS.NegotiatedProtocol
+}
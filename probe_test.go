@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTTFBProberOutcomes(t *testing.T) {
+	tests := []struct {
+		name string
+		kind listenerKind
+		want func(float64) bool
+	}{
+		{"speaks first", listenGreet, func(ms float64) bool { return ms > 0 }},
+		{"waits for client", listenOpen, func(ms float64) bool { return ms == ttfbSilent }},
+		{"hangs up", listenAcceptClose, func(ms float64) bool { return ms == ttfbClosed }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := PortInfo{Port: testListener(t, tt.kind)}
+			ttfbProber{timeout: 200 * time.Millisecond}.Probe(context.Background(), "127.0.0.1", &info)
+			if !tt.want(info.TTFBMs) {
+				t.Errorf("ttfb_ms = %v", info.TTFBMs)
+			}
+		})
+	}
+}
+
+func TestTLSProberVirtualHost(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	// The probe hangs up after the handshake, which the server would log
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	tests := []struct {
+		name string
+		req  ScanRequest
+		want string
+	}{
+		{"sni only", ScanRequest{TLSProbe: true, TLSServerName: "sni.example"}, ""},
+		{"vhost", ScanRequest{TLSProbe: true, VirtualHost: "app.example"}, "app.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := PortInfo{Port: port}
+			for _, prober := range probersFor(tt.req) {
+				prober.Probe(context.Background(), "127.0.0.1", &info)
+			}
+			if !info.TLS {
+				t.Fatal("TLS handshake failed")
+			}
+			if info.VirtualHost != tt.want {
+				t.Errorf("virtual host = %q, want %q", info.VirtualHost, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSOCKS5 starts a SOCKS5 proxy that answers every CONNECT with code
+func fakeSOCKS5(t *testing.T, code byte) *url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				greeting := make([]byte, 3)
+				if _, err := io.ReadFull(conn, greeting); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, 0x00})
+				// CONNECT to an IPv4 address: header, address and port
+				request := make([]byte, 4+net.IPv4len+2)
+				if _, err := io.ReadFull(conn, request); err != nil {
+					return
+				}
+				conn.Write([]byte{0x05, code, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "socks5", Host: l.Addr().String()}
+}
+
+func TestProxyReplyState(t *testing.T) {
+	tests := []struct {
+		code  byte
+		state string
+	}{
+		{socksReplyRefused, "closed"},
+		{0x01, "filtered"}, // general failure
+		{0x03, "filtered"}, // network unreachable
+		{0x04, "filtered"}, // host unreachable
+		{0x06, "filtered"}, // TTL expired
+	}
+	for _, tt := range tests {
+		chain := proxyChain{fakeSOCKS5(t, tt.code)}
+		dialer := &net.Dialer{Timeout: time.Second}
+		conn, err := chain.DialContext(context.Background(), dialer, "192.0.2.1:80")
+		if err == nil {
+			conn.Close()
+			t.Fatalf("reply %d: dial succeeded", tt.code)
+		}
+		if got := closedState(err); got != tt.state {
+			t.Errorf("reply %d: state = %q, want %q (%v)", tt.code, got, tt.state, err)
+		}
+		if refused := errors.Is(err, syscall.ECONNREFUSED); refused != (tt.code == socksReplyRefused) {
+			t.Errorf("reply %d: reported as refused = %v", tt.code, refused)
+		}
+	}
+}
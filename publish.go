@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// ResultPublisher pushes open ports to an external system as they are found
+type ResultPublisher interface {
+	Publish(PortInfo) error
+}
+
+// ResultEvent is the message published for each open port
+type ResultEvent struct {
+	Target    string    `json:"target"`
+	Port      PortInfo  `json:"port"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// publisherFactories maps URL schemes to publisher constructors. Backends
+// with extra requirements register themselves from files behind build tags.
+var publisherFactories = map[string]func(u *url.URL, target string) (ResultPublisher, error){}
+
+// publisherBuildTags names the build tag that enables each known backend
+var publisherBuildTags = map[string]string{
+	"nats": "nats",
+}
+
+// NewPublisher creates a publisher for a URL such as nats://host:4222/subject
+func NewPublisher(rawURL, target string) (ResultPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid publish URL: %v", err)
+	}
+	factory, ok := publisherFactories[u.Scheme]
+	if !ok {
+		if tag, known := publisherBuildTags[u.Scheme]; known {
+			return nil, fmt.Errorf("%s publishing is not built in; rebuild with -tags %s", u.Scheme, tag)
+		}
+		return nil, fmt.Errorf("unsupported publish scheme %q", u.Scheme)
+	}
+	return factory(u, target)
+}
+
+// asyncPublisher queues results so a slow or failing backend never blocks
+// the scan. Failures are logged and the scan carries on.
+type asyncPublisher struct {
+	backend ResultPublisher
+	queue   chan PortInfo
+	wg      sync.WaitGroup
+}
+
+// publishQueueSize is how many results may wait for the backend before
+// new ones are dropped
+const publishQueueSize = 1024
+
+func newAsyncPublisher(backend ResultPublisher) *asyncPublisher {
+	p := &asyncPublisher{backend: backend, queue: make(chan PortInfo, publishQueueSize)}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for info := range p.queue {
+			if err := p.backend.Publish(info); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to publish port %d: %v\n", info.Port, err)
+			}
+		}
+	}()
+	return p
+}
+
+// Publish implements ResultPublisher without waiting for the backend
+func (p *asyncPublisher) Publish(info PortInfo) error {
+	select {
+	case p.queue <- info:
+	default:
+		fmt.Fprintf(os.Stderr, "Warning: publish queue full, dropped port %d\n", info.Port)
+	}
+	return nil
+}
+
+// Close waits for queued results to be sent
+func (p *asyncPublisher) Close() {
+	close(p.queue)
+	p.wg.Wait()
+	if closer, ok := p.backend.(interface{ Close() error }); ok {
+		if err := closer.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to close publisher: %v\n", err)
+		}
+	}
+}
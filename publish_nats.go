@@ -0,0 +1,151 @@
+//go:build nats
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNATSSubject is used when the publish URL has no path
+const defaultNATSSubject = "portscanner.results"
+
+// natsWriteTimeout bounds each write so a stalled server cannot hang the scan
+const natsWriteTimeout = 5 * time.Second
+
+func init() {
+	publisherFactories["nats"] = newNATSPublisher
+}
+
+// natsPublisher speaks the NATS text protocol over a plain TCP connection
+type natsPublisher struct {
+	conn    net.Conn
+	subject string
+	target  string
+	mu      sync.Mutex
+	// pong receives the server's answers to our PINGs, and done is closed
+	// when the connection stops being read
+	pong chan struct{}
+	done chan struct{}
+}
+
+// natsConnect is the CONNECT command's options. User and Pass come from the
+// publish URL's userinfo.
+type natsConnect struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	Name     string `json:"name"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+}
+
+func newNATSPublisher(u *url.URL, target string) (ResultPublisher, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	subject := strings.Trim(u.Path, "/")
+	if subject == "" {
+		subject = defaultNATSSubject
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, natsWriteTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+
+	// The server greets with INFO before accepting commands
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(natsWriteTimeout))
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected NATS greeting: %q", strings.TrimSpace(line))
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	options := natsConnect{Name: "port-scanner"}
+	if u.User != nil {
+		options.User = u.User.Username()
+		options.Pass, _ = u.User.Password()
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	p := &natsPublisher{conn: conn, subject: subject, target: target, pong: make(chan struct{}, 1), done: make(chan struct{})}
+	if err := p.write("CONNECT " + string(connect) + "\r\n"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go p.readLoop(reader)
+	return p, nil
+}
+
+// readLoop answers server keepalives and reports protocol errors
+func (p *natsPublisher) readLoop(reader *bufio.Reader) {
+	defer close(p.done)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(line, "PING"):
+			p.write("PONG\r\n")
+		case strings.HasPrefix(line, "PONG"):
+			select {
+			case p.pong <- struct{}{}:
+			default:
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			fmt.Fprintf(os.Stderr, "Warning: NATS error: %s\n", strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+func (p *natsPublisher) write(s string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	_, err := p.conn.Write([]byte(s))
+	return err
+}
+
+// Publish implements ResultPublisher
+func (p *natsPublisher) Publish(info PortInfo) error {
+	payload, err := json.Marshal(ResultEvent{Target: p.target, Port: info, Timestamp: time.Now()})
+	if err != nil {
+		return err
+	}
+	return p.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", p.subject, len(payload), payload))
+}
+
+// Close flushes the connection and disconnects. The server handles
+// commands in order, so its PONG means every earlier PUB was processed.
+func (p *natsPublisher) Close() error {
+	var err error
+	if err = p.write("PING\r\n"); err == nil {
+		select {
+		case <-p.pong:
+		case <-p.done:
+			err = errors.New("NATS connection closed before the flush completed")
+		case <-time.After(natsWriteTimeout):
+			err = errors.New("timed out flushing NATS publishes")
+		}
+	}
+	if cerr := p.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
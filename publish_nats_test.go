@@ -0,0 +1,88 @@
+//go:build nats
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATS accepts one client, records its CONNECT options and subjects
+// published to, and answers PING only after a delay
+func fakeNATS(t *testing.T, pongDelay time.Duration) (string, <-chan natsConnect, <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	connects := make(chan natsConnect, 1)
+	pubs := make(chan string, 16)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("INFO {}\r\n"))
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			verb, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch verb {
+			case "CONNECT":
+				var options natsConnect
+				json.Unmarshal([]byte(rest), &options)
+				connects <- options
+			case "PUB":
+				reader.ReadString('\n') // payload
+				pubs <- rest
+			case "PING":
+				time.Sleep(pongDelay)
+				conn.Write([]byte("PONG\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), connects, pubs
+}
+
+func TestNATSPublisherCredentialsAndFlush(t *testing.T) {
+	addr, connects, pubs := fakeNATS(t, 200*time.Millisecond)
+	u, _ := url.Parse("nats://scanner:s3cret@" + addr + "/scans")
+	p, err := newNATSPublisher(u, "10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(PortInfo{Port: 22, State: "open"}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := p.(*natsPublisher).Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Close returned after %v, before the server's PONG", elapsed)
+	}
+
+	options := <-connects
+	if options.User != "scanner" || options.Pass != "s3cret" {
+		t.Errorf("CONNECT user/pass = %q/%q, want the URL's userinfo", options.User, options.Pass)
+	}
+	select {
+	case pub := <-pubs:
+		if !strings.HasPrefix(pub, "scans ") {
+			t.Errorf("published %q, want subject scans", pub)
+		}
+	default:
+		t.Error("the publish had not reached the server when Close returned")
+	}
+}
@@ -1,89 +1,637 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"net"
+	"os"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-// ScanPorts performs port scanning with concurrency control
-func ScanPorts(hostname string, startPort, endPort, maxConcurrent int, timeout time.Duration, verbose bool) ([]PortInfo, time.Duration) {
+// verifyTimeoutFactor multiplies the timeout used for the verification pass
+const verifyTimeoutFactor = 3
+
+// minProgressPorts is the smallest scan that prints a running progress line
+const minProgressPorts = 10
+
+// autoConcurrencyPerCPU is the per-CPU connection limit used by
+// "-concurrent auto"; it matches the default of 100 on a four-core machine
+const autoConcurrencyPerCPU = 25
+
+// maxConcurrencyLimit is the most connections per host a scan may request
+const maxConcurrencyLimit = 10000
+
+// fdReserve is how many file descriptors automatic concurrency leaves free
+// for output files, probes and the web server
+const fdReserve = 64
+
+// globalDials, when set, bounds the dials in flight across every scan in
+// the process, on top of each scan's own MaxConcurrent. The web server sets
+// it from -global-concurrency.
+var globalDials chan struct{}
+
+// ScanOptions controls how ScanPorts dials each port
+type ScanOptions struct {
+	MaxConcurrent int
+	Timeout       time.Duration
+	JitterMs      int
+	Verbose       bool
+	// ProgressEvery is how many completed ports between progress updates;
+	// 0 picks an interval based on the number of ports
+	ProgressEvery int
+	// StreamOnly delivers open ports to OnOpen as soon as they are found
+	// and does not collect them, keeping memory constant for huge scans
+	StreamOnly bool
+	// OnOpen is called for every open port; calls are never concurrent
+	OnOpen func(PortInfo)
+	// OnProgress is called at the same cadence as the verbose progress line
+	OnProgress func(done, total int)
+	// Skip, when set, leaves out the ports it reports as already scanned
+	// on the address
+	Skip func(addr string, spec PortSpec) bool
+	// OnDone, when set, is called once a port on the address has its
+	// result: after OnOpen for an open port, or once its dial failed
+	OnDone func(addr string, spec PortSpec)
+	// FailFast aborts the scan on the first dial error that is not a normal
+	// closed-port result, such as "network unreachable"
+	FailFast bool
+	// Probers run against each open port before it is reported
+	Probers []Prober
+	// ClosedSample keeps up to this many closed ports as evidence of coverage
+	ClosedSample int
+	// ProbeHost, when set, is the name probers connect to instead of the
+	// dialed address, so HTTP and TLS probes send the hostname
+	ProbeHost string
+	// FilteredTimeout, when set, shortens the dial timeout once the host has
+	// answered, so filtered ports stop costing the full Timeout
+	FilteredTimeout time.Duration
+	// SYN uses the raw socket half-open backend where it is available and
+	// falls back to connect scanning elsewhere
+	SYN bool
+	// SourcePorts, when set, binds every dial to a local port from the pool
+	SourcePorts *SourcePortPool
+	// DeadHostThreshold, when set, aborts the scan after this many TCP dials
+	// in a row time out, on the assumption that the host has gone down
+	DeadHostThreshold int
+	// Turn, when set, makes each dispatch wait for this host's turn in an
+	// interleaved multi-host scan
+	Turn *hostTurn
+	// Retries is how many times scanHost re-dials the ports that timed out,
+	// after the main pass
+	Retries int
+	// Backoff is the pause before each retry round
+	Backoff Backoff
+	// SlowStart begins with a few dials in flight and ramps up towards
+	// MaxConcurrent while timeouts stay steady
+	SlowStart bool
+	// PortTimeouts replaces Timeout for the listed ports. An overridden port
+	// is also exempt from the FilteredTimeout lane.
+	PortTimeouts map[int]time.Duration
+	// Pause, when set, holds back new dials while the scan is paused
+	Pause *pauseGate
+	// Proxies, when set, routes every TCP dial through this SOCKS5 chain
+	Proxies proxyChain
+	// DialInterval, when set, is the least time between the start of two
+	// dials, so the host's accept queue can keep up
+	DialInterval time.Duration
+	// TCPInfo records the negotiated TCP parameters of each open port
+	TCPInfo bool
+	// TCP and UDP, when set, replace MaxConcurrent and Timeout for the
+	// ports of that protocol, and a mixed scan runs the two side by side
+	TCP, UDP ProtocolLimits
+}
+
+// targetFriendlyInterval spaces out dial starts for TargetFriendly scans,
+// about 500 per second, which small devices with a short accept backlog
+// keep up with
+const targetFriendlyInterval = 2 * time.Millisecond
+
+// ScanResult is the outcome of a ScanPorts call
+type ScanResult struct {
+	OpenPorts []PortInfo
+	OpenCount int
+	Duration  time.Duration
+	// Err is set when a fail-fast scan was aborted by a systemic dial error
+	Err error
+	// PeakConcurrency is the most dials that were in flight at once and
+	// AvgConcurrency the mean number in flight whenever a dial started
+	PeakConcurrency int
+	AvgConcurrency  float64
+	dials           int
+	ClosedSample    []PortInfo
+	// Errored lists ports whose scan panicked and has no result
+	Errored []int
+	// HostDown is set when the scan was aborted by DeadHostThreshold
+	HostDown bool
+	// Retried counts the timed-out ports that were re-dialed and
+	// RetryResolved those that answered (open or refused) on a retry
+	Retried       int
+	RetryResolved int
+	// timedOut lists the ports whose dial timed out, for the retry queue
+	timedOut []PortSpec
+	// ConcurrencyCurve records how a slow-start scan changed its limit
+	ConcurrencyCurve []ConcurrencyStep
+	// ProbeTime is the time spent in probers, summed over the open ports
+	ProbeTime time.Duration
+}
+
+// add folds the outcome of another pass into the result
+func (r *ScanResult) add(o ScanResult) {
+	r.OpenPorts = append(r.OpenPorts, o.OpenPorts...)
+	r.OpenCount += o.OpenCount
+	r.ClosedSample = append(r.ClosedSample, o.ClosedSample...)
+	r.Errored = append(r.Errored, o.Errored...)
+	r.HostDown = r.HostDown || o.HostDown
+	r.Retried += o.Retried
+	if r.ConcurrencyCurve == nil {
+		r.ConcurrencyCurve = o.ConcurrencyCurve
+	}
+	r.RetryResolved += o.RetryResolved
+	r.Duration += o.Duration
+	r.ProbeTime += o.ProbeTime
+	if o.Err != nil {
+		r.Err = o.Err
+	}
+	r.PeakConcurrency = max(r.PeakConcurrency, o.PeakConcurrency)
+	if dials := r.dials + o.dials; dials > 0 {
+		r.AvgConcurrency = (r.AvgConcurrency*float64(r.dials) + o.AvgConcurrency*float64(o.dials)) / float64(dials)
+		r.dials = dials
+	}
+}
+
+// ScanCallbacks receive results while a scan is still running
+type ScanCallbacks struct {
+	OnOpen     func(PortInfo)
+	OnProgress func(done, total int)
+	// Pause, when set, lets the caller pause and resume the scan
+	Pause *pauseGate
+	// Skip and OnDone let the caller resume an interrupted scan; see
+	// ScanOptions
+	Skip   func(addr string, spec PortSpec) bool
+	OnDone func(addr string, spec PortSpec)
+}
+
+// ScanPorts performs port scanning with concurrency control. Each entry is
+// dialed over its own protocol.
+func ScanPorts(ctx context.Context, hostname string, ports []PortSpec, opts ScanOptions) ScanResult {
+	if opts.Skip != nil {
+		ports = slices.DeleteFunc(slices.Clone(ports), func(spec PortSpec) bool { return opts.Skip(hostname, spec) })
+		opts.Skip = nil
+	}
+	if opts.TCP.set() || opts.UDP.set() {
+		return scanByProtocol(ctx, hostname, ports, opts)
+	}
+	if opts.SYN {
+		// SYN scanning only covers TCP; UDP entries are scanned normally
+		tcp, udp := splitProtocols(ports)
+		result, err := synScanPorts(ctx, hostname, tcp, opts)
+		if err == nil {
+			if len(udp) > 0 {
+				udpOpts := opts
+				udpOpts.SYN = false
+				result.add(ScanPorts(ctx, hostname, udp, udpOpts))
+			}
+			return result
+		}
+		fmt.Fprintf(os.Stderr, "Warning: SYN scan of %s unavailable, using connect scan: %v\n", hostname, err)
+	}
+
 	start := time.Now()
-	totalPorts := endPort - startPort + 1
-	results := make(chan PortInfo, totalPorts)
-	semaphore := make(chan struct{}, maxConcurrent)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	totalPorts := len(ports)
+	bufferSize := totalPorts
+	if opts.StreamOnly {
+		bufferSize = 0
+	}
+	results := make(chan PortInfo, bufferSize)
+	semaphore := make(chan struct{}, opts.MaxConcurrent)
+	global := globalDials
+	dialer := net.Dialer{Timeout: opts.Timeout}
+	verbose := opts.Verbose
 	var wg sync.WaitGroup
 
 	// For simple progress updates in verbose mode
 	scanProgress := 0
 	var progressMutex sync.Mutex
+	progressEvery := opts.ProgressEvery
+	if progressEvery <= 0 {
+		progressEvery = autoProgressInterval(totalPorts)
+	}
+
+	// Slowest open or refused answer, used to size the filtered-port lane
+	var slowestAnswer atomic.Int64
+
+	// Consecutive TCP timeouts, for the dead host circuit breaker
+	var timeoutRun atomic.Int64
+	var hostDown atomic.Bool
+
+	// In-flight dial counters for the concurrency metrics
+	var inFlight, peak, inFlightSum, dials atomic.Int64
+
+	// Time spent in probers across all workers
+	var probeTime atomic.Int64
+
+	// First systemic error seen in fail-fast mode
+	var scanErr error
+	var failOnce sync.Once
+
+	// Sample of closed ports kept for the response
+	var closedSample []PortInfo
+	var sampleMutex sync.Mutex
+
+	// Ports whose worker panicked
+	var errored []int
+	var erroredMutex sync.Mutex
+
+	// Ports whose dial timed out, queued for a retry after the main pass
+	var timedOut []PortSpec
+	var timedOutMutex sync.Mutex
+
+	// Open ports delivered directly from the workers in stream-only mode
+	streamedOpen := 0
+	var streamMutex sync.Mutex
 
 	if verbose {
-		fmt.Printf("Starting scan of %d ports on %s...\n", totalPorts, hostname)
+		if totalPorts == 1 {
+			fmt.Printf("Starting scan of port %d on %s...\n", ports[0].Port, hostname)
+		} else {
+			fmt.Printf("Starting scan of %d ports on %s...\n", totalPorts, hostname)
+		}
+	}
+	// A running count is just noise when there are only a few ports
+	showProgress := verbose && totalPorts >= minProgressPorts
+
+	var window *slowStart
+	if opts.SlowStart {
+		window = newSlowStart(ctx, opts.MaxConcurrent)
+	}
+	var pace <-chan time.Time
+	if opts.DialInterval > 0 {
+		ticker := time.NewTicker(opts.DialInterval)
+		defer ticker.Stop()
+		pace = ticker.C
 	}
 
-	for port := startPort; port <= endPort; port++ {
+dispatch:
+	for i, port := range ports {
+		if opts.Pause.wait(ctx) != nil {
+			break
+		}
+		if pace != nil && i > 0 {
+			select {
+			case <-pace:
+			case <-ctx.Done():
+				break dispatch
+			}
+		}
+		if opts.Turn.wait(ctx) != nil {
+			break
+		}
+		if window != nil && window.acquire(ctx) != nil {
+			break
+		}
+		select {
+		case semaphore <- struct{}{}: // Acquire semaphore
+		case <-ctx.Done():
+			if window != nil {
+				window.release(false)
+			}
+			break dispatch
+		}
+		// Then a slot in the server-wide budget shared with other scans
+		if global != nil {
+			select {
+			case global <- struct{}{}:
+			case <-ctx.Done():
+				<-semaphore
+				if window != nil {
+					window.release(false)
+				}
+				break dispatch
+			}
+		}
+		opts.Turn.pass()
 		wg.Add(1)
-		semaphore <- struct{}{} // Acquire semaphore
-		go func(p int) {
+		go func(spec PortSpec) {
+			p := spec.Port
+			timedOutDial := false
 			defer wg.Done()
 			defer func() { <-semaphore }() // Release semaphore
+			if global != nil {
+				defer func() { <-global }()
+			}
+			if window != nil {
+				defer func() { window.release(timedOutDial) }()
+			}
+			// A bug in one worker must not take down the whole scan
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "Warning: scan of port %d panicked: %v\n", p, r)
+					erroredMutex.Lock()
+					errored = append(errored, p)
+					erroredMutex.Unlock()
+				}
+			}()
+
+			current := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			inFlightSum.Add(current)
+			dials.Add(1)
+			for {
+				seen := peak.Load()
+				if current <= seen || peak.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+
+			// Random delay before dialing so the probe timing is irregular
+			if opts.JitterMs > 0 {
+				delay := time.Duration(rand.IntN(opts.JitterMs+1)) * time.Millisecond
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			baseDialer := dialer
+			override, overridden := opts.PortTimeouts[p]
+			if overridden {
+				baseDialer.Timeout = override
+			}
+
+			dialCtx := ctx
+			if opts.FilteredTimeout > 0 && !overridden {
+				var cancelDial context.CancelFunc
+				dialCtx, cancelDial = context.WithTimeout(ctx, filteredLaneTimeout(opts, time.Duration(slowestAnswer.Load())))
+				defer cancelDial()
+			}
+
+			portDialer := &baseDialer
+			if opts.SourcePorts != nil {
+				srcPort, err := opts.SourcePorts.Acquire(ctx)
+				if err != nil {
+					return
+				}
+				// Deferred so a panic below cannot leak the port
+				defer opts.SourcePorts.Release(srcPort)
+				portDialer = opts.SourcePorts.Dialer(baseDialer, spec.Protocol, srcPort)
+			}
 
 			address := net.JoinHostPort(hostname, strconv.Itoa(p))
-			conn, err := net.DialTimeout("tcp", address, timeout)
+			dialStart := time.Now()
+			var conn net.Conn
+			var err error
+			if spec.Protocol == protoUDP {
+				conn, err = dialUDP(dialCtx, portDialer, address, p)
+			} else if len(opts.Proxies) > 0 {
+				conn, err = opts.Proxies.DialContext(dialCtx, portDialer, address)
+			} else {
+				conn, err = portDialer.DialContext(dialCtx, "tcp", address)
+			}
+			elapsed := time.Since(dialStart)
+			connectMs := float64(elapsed.Microseconds()) / 1000
+
+			if err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+				for {
+					seen := slowestAnswer.Load()
+					if int64(elapsed) <= seen || slowestAnswer.CompareAndSwap(seen, int64(elapsed)) {
+						break
+					}
+				}
+			}
 
-			// Update progress counter if in verbose mode
-			if verbose {
-				progressMutex.Lock()
-				scanProgress++
-				if scanProgress%100 == 0 || scanProgress == totalPorts {
-					fmt.Printf("\rScanning... %d/%d ports completed (%d%%)",
-						scanProgress, totalPorts, scanProgress*100/totalPorts)
+			// Any answer resets the breaker; only TCP counts since silence is
+			// normal for UDP
+			if opts.DeadHostThreshold > 0 && spec.Protocol != protoUDP && ctx.Err() == nil {
+				if err != nil && closedState(err) == "filtered" {
+					if timeoutRun.Add(1) >= int64(opts.DeadHostThreshold) && hostDown.CompareAndSwap(false, true) {
+						cancel()
+					}
+				} else {
+					timeoutRun.Store(0)
 				}
-				progressMutex.Unlock()
+			}
+
+			if err != nil && opts.FailFast && isSystemicDialError(err) {
+				failOnce.Do(func() {
+					scanErr = fmt.Errorf("port %d: %v", p, err)
+					cancel()
+				})
+				return
+			}
+
+			// Update progress counter if in verbose mode or someone is listening
+			// The callbacks run under locks released by defer, so a panicking
+			// callback cannot leave the other workers blocked
+			if showProgress || opts.OnProgress != nil {
+				func() {
+					progressMutex.Lock()
+					defer progressMutex.Unlock()
+					scanProgress++
+					if scanProgress%progressEvery == 0 || scanProgress == totalPorts {
+						if showProgress {
+							fmt.Printf("\rScanning... %d/%d ports completed (%d%%)",
+								scanProgress, totalPorts, scanProgress*100/totalPorts)
+						}
+						if opts.OnProgress != nil {
+							opts.OnProgress(scanProgress, totalPorts)
+						}
+					}
+				}()
+			}
+
+			if err != nil && closedState(err) == "filtered" && ctx.Err() == nil {
+				timedOutDial = true
+				timedOutMutex.Lock()
+				timedOut = append(timedOut, spec)
+				timedOutMutex.Unlock()
+			}
+
+			if err != nil && opts.ClosedSample > 0 && ctx.Err() == nil {
+				sampleMutex.Lock()
+				if len(closedSample) < opts.ClosedSample {
+					closedSample = append(closedSample, PortInfo{Port: p, Protocol: spec.Protocol, Service: CommonPorts[p], State: portState(spec, err)})
+				}
+				sampleMutex.Unlock()
+			}
+
+			if err != nil && opts.OnDone != nil && ctx.Err() == nil {
+				opts.OnDone(hostname, spec)
 			}
 
 			if err == nil {
-				service, exists := CommonPorts[p]
-				if !exists {
-					service = "unknown"
+				info := PortInfo{Port: p, Protocol: spec.Protocol, Service: serviceName(p), State: "open", ConnectMs: connectMs}
+				// Discovery order is when the dial answered, not when the
+				// probes below finish
+				info.found = dialStart.Add(elapsed)
+				// Through a proxy the connection's peer is the proxy
+				if opts.TCPInfo && spec.Protocol != protoUDP && len(opts.Proxies) == 0 {
+					info.TCPInfo = readTCPInfo(conn)
 				}
-				results <- PortInfo{Port: p, Service: service, State: "open"}
 				conn.Close()
+				probeHost := hostname
+				if opts.ProbeHost != "" {
+					probeHost = opts.ProbeHost
+				}
+				// Probers speak TCP-based protocols
+				if spec.Protocol != protoUDP && len(opts.Probers) > 0 {
+					probeStart := time.Now()
+					runProbers(ctx, opts.Probers, probeHost, &info)
+					probeTime.Add(int64(time.Since(probeStart)))
+				}
+				if opts.StreamOnly {
+					func() {
+						streamMutex.Lock()
+						defer streamMutex.Unlock()
+						streamedOpen++
+						if opts.OnOpen != nil {
+							opts.OnOpen(info)
+						}
+					}()
+					if opts.OnDone != nil {
+						opts.OnDone(hostname, spec)
+					}
+				} else {
+					results <- info
+				}
 			}
 		}(port)
 	}
+	// Nothing is left to dispatch, so other hosts need not wait for this one
+	opts.Turn.finish()
 
 	go func() {
 		wg.Wait()
 		close(results)
+		if showProgress {
+			fmt.Println()
+		}
 		if verbose {
-			fmt.Println("\nScan complete!")
+			fmt.Println("Scan complete!")
 		}
 	}()
 
 	var openPorts []PortInfo
 	for portInfo := range results {
+		if opts.OnOpen != nil {
+			opts.OnOpen(portInfo)
+		}
+		if opts.OnDone != nil {
+			opts.OnDone(hostname, PortSpec{Port: portInfo.Port, Protocol: portInfo.Protocol})
+		}
 		openPorts = append(openPorts, portInfo)
 	}
 
 	// Sort the results by port number
-	sort.Slice(openPorts, func(i, j int) bool {
-		return openPorts[i].Port < openPorts[j].Port
-	})
+	sortPortInfos(openPorts)
+	sort.Ints(errored)
+
+	openCount := len(openPorts)
+	if opts.StreamOnly {
+		openCount = streamedOpen
+	}
 
-	return openPorts, time.Since(start)
+	result := ScanResult{
+		OpenPorts:       openPorts,
+		OpenCount:       openCount,
+		ClosedSample:    closedSample,
+		Errored:         errored,
+		Duration:        time.Since(start),
+		Err:             scanErr,
+		HostDown:        hostDown.Load(),
+		timedOut:        timedOut,
+		PeakConcurrency: int(peak.Load()),
+		dials:           int(dials.Load()),
+		ProbeTime:       time.Duration(probeTime.Load()),
+	}
+	if result.dials > 0 {
+		result.AvgConcurrency = float64(inFlightSum.Load()) / float64(result.dials)
+	}
+	if window != nil {
+		result.ConcurrencyCurve = window.steps()
+	}
+	return result
+}
+
+// closedState labels a failed dial: a timeout means the port is filtered,
+// anything else (usually a refusal) means it is closed
+func closedState(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "filtered"
+	}
+	// A proxy that failed to reach the target for any reason but a refusal
+	// cannot say whether the port is closed
+	var reply *socksReplyError
+	if errors.As(err, &reply) && reply.code != socksReplyRefused {
+		return "filtered"
+	}
+	return "closed"
+}
+
+// portState is the state of a port whose dial failed. An unanswered UDP
+// datagram cannot tell an open port from a filtered one.
+func portState(spec PortSpec, err error) string {
+	state := closedState(err)
+	if spec.Protocol == protoUDP && state == "filtered" {
+		return "open|filtered"
+	}
+	return state
+}
+
+// filteredLaneTimeout is the dial timeout once the host has shown how fast it
+// answers: connections that are open or refused come back within one round
+// trip, so waiting much longer than the slowest answer only delays filtered
+// ports. Until the first answer arrives the full timeout is used.
+func filteredLaneTimeout(opts ScanOptions, slowestAnswer time.Duration) time.Duration {
+	if slowestAnswer == 0 {
+		return opts.Timeout
+	}
+	return min(max(opts.FilteredTimeout, 2*slowestAnswer), opts.Timeout)
+}
+
+// isSystemicDialError reports whether a dial error points at a problem with
+// the scanner or network rather than the state of the port. Timeouts,
+// refusals and resets are ordinary closed or filtered results.
+func isSystemicDialError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+	return true
 }
 
 // RunScan executes a port scan with the given parameters
 func RunScan(req ScanRequest, verbose bool) ScanResponse {
-	maxConcurrent := req.MaxConcurrent
-	if maxConcurrent <= 0 {
-		maxConcurrent = 100
-	}
+	return RunScanContext(context.Background(), req, verbose, ScanCallbacks{})
+}
+
+// RunScanContext executes a port scan that stops early when ctx is cancelled
+func RunScanContext(ctx context.Context, req ScanRequest, verbose bool, cb ScanCallbacks) ScanResponse {
+	idempotencyKey := IdempotencyKey(req)
+	maxConcurrent := resolveConcurrency(req)
+	meta := BuildMeta()
+	meta.MaxConcurrent = maxConcurrent
 
 	timeoutMs := req.TimeoutMs
 	if timeoutMs <= 0 {
@@ -91,19 +639,674 @@ func RunScan(req ScanRequest, verbose bool) ScanResponse {
 	}
 	timeout := time.Duration(timeoutMs) * time.Millisecond
 
-	openPortsInfo, duration := ScanPorts(req.Host, req.StartPort, req.EndPort, maxConcurrent, timeout, verbose)
+	opts := ScanOptions{
+		MaxConcurrent:     maxConcurrent,
+		Timeout:           timeout,
+		JitterMs:          req.JitterMs,
+		Verbose:           verbose,
+		ProgressEvery:     req.ProgressEvery,
+		StreamOnly:        req.StreamOnly,
+		FailFast:          req.FailFast,
+		Probers:           probersFor(req),
+		ClosedSample:      req.ClosedSample,
+		FilteredTimeout:   time.Duration(req.FilteredTimeoutMs) * time.Millisecond,
+		SYN:               req.SYN,
+		DeadHostThreshold: req.DeadHostThreshold,
+		Retries:           req.Retries,
+		Backoff:           backoffFor(req),
+		SlowStart:         req.SlowStart,
+		PortTimeouts:      portTimeouts(req.TimeoutOverrides),
+		Pause:             cb.Pause,
+		OnOpen:            cb.OnOpen,
+		OnProgress:        cb.OnProgress,
+		Skip:              cb.Skip,
+		OnDone:            cb.OnDone,
+	}
+	if req.TargetFriendly {
+		opts.DialInterval = targetFriendlyInterval
+	}
+	opts.TCPInfo = req.TCPInfo
+	opts.TCP = ProtocolLimits{
+		MaxConcurrent: req.TCPConcurrency,
+		Timeout:       time.Duration(req.TCPTimeoutMs) * time.Millisecond,
+	}
+	opts.UDP = ProtocolLimits{
+		MaxConcurrent: req.UDPConcurrency,
+		Timeout:       time.Duration(req.UDPTimeoutMs) * time.Millisecond,
+	}
+
+	ports := shardPorts(portSpecs(ResolvePorts(req), req.UDPPorts), req.ShardIndex, req.ShardCount)
+	startPort, endPort := req.StartPort, req.EndPort
+	if len(ports) > 0 {
+		startPort, endPort = portBounds(ports)
+	}
+
+	name := req.Host
+	if req.SRV != "" {
+		name = req.SRV
+	}
+	failed := func(err string) ScanResponse {
+		return ScanResponse{
+			Target:         name,
+			StartPort:      startPort,
+			EndPort:        endPort,
+			Status:         StatusError,
+			Error:          err,
+			Timestamp:      time.Now(),
+			Meta:           meta,
+			IdempotencyKey: idempotencyKey,
+		}
+	}
+
+	if len(req.Proxies) > 0 {
+		chain, err := ParseProxyChain(req.Proxies)
+		if err != nil {
+			return failed(err.Error())
+		}
+		opts.Proxies = chain
+	}
+
+	if req.SourcePorts != "" {
+		start, end, err := ParsePortRange(req.SourcePorts)
+		if err != nil {
+			return failed(err.Error())
+		}
+		opts.SourcePorts = NewSourcePortPool(start, end)
+	}
+
+	// Resolve hostnames up front so the response records every address and
+	// which one was dialed: the first, or all of them for dual-stack scans.
+	// An SRV record instead supplies its own targets, each with its ports.
+	var targets []scanTarget
+	var plan [][]PortSpec
+	var resolved []string
+	var resolveTime time.Duration
+	var endpoints []SRVEndpoint
+	wildcard := false
+	labelled := req.DualStack
+	if req.SRV != "" {
+		resolveStart := time.Now()
+		var err error
+		endpoints, err = ResolveSRV(ctx, req.SRV)
+		resolveTime = time.Since(resolveStart)
+		if err != nil {
+			return failed(err.Error())
+		}
+		targets, plan = srvPlan(req.SRV, endpoints)
+		if len(targets) == 0 {
+			return failed(fmt.Sprintf("no target of SRV record %s resolved", req.SRV))
+		}
+		for _, target := range targets {
+			resolved = append(resolved, target.addr)
+		}
+		startPort, endPort = portBounds(slices.Concat(plan...))
+		labelled = true
+	} else {
+		hosts, err := ExpandTargets([]string{req.Host})
+		if err != nil {
+			return failed(err.Error())
+		}
+		labelled = labelled || len(hosts) > 1
+		for _, h := range hosts {
+			if net.ParseIP(h.Host) != nil {
+				targets = append(targets, scanTarget{addr: h.Host, name: h.Host})
+				continue
+			}
+			// The wildcard check is part of resolving, so it counts toward
+			// the resolve time
+			resolveStart := time.Now()
+			addrs, err := lookupHost(ctx, h.Host)
+			if err == nil && !req.SkipWildcardCheck && DetectWildcardDNS(ctx, h.Host, addrs) {
+				wildcard = true
+			}
+			resolveTime += time.Since(resolveStart)
+			if err != nil {
+				return failed(fmt.Sprintf("failed to resolve hostname %s: %v", h.Host, err))
+			}
+			resolved = append(resolved, addrs...)
+			if req.DualStack {
+				for _, addr := range addrs {
+					targets = append(targets, scanTarget{addr: addr, name: h.Host})
+				}
+			} else {
+				targets = append(targets, scanTarget{addr: addrs[0], probeHost: h.Host, name: h.Host})
+			}
+		}
+		plan = make([][]PortSpec, len(targets))
+		for i := range targets {
+			plan[i] = ports
+		}
+	}
+	for _, target := range targets {
+		if err := scanPolicy.Permits(target.addr); err != nil {
+			return failed(err.Error())
+		}
+	}
+
+	// Enforce the dial budget across every target and port
+	truncated := false
+	dials := 0
+	for _, targetPorts := range plan {
+		dials += len(targetPorts)
+	}
+	if req.MaxDials > 0 && dials > req.MaxDials {
+		if req.MaxDialsMode != "truncate" {
+			return failed(fmt.Sprintf("scan needs %d connections, exceeding the limit of %d", dials, req.MaxDials))
+		}
+		plan = truncatePlan(plan, req.MaxDials)
+		truncated = true
+	}
+
+	// Hosts are scanned HostConcurrency at a time, each with its own pool of
+	// MaxConcurrent dials. Progress lines would interleave, so they are only
+	// printed when hosts run one at a time.
+	hostConcurrency := max(req.HostConcurrency, 1)
+	// Interleaved hosts all run at once, taking turns port by port
+	var turns *portTurns
+	if req.Interleave && len(targets) > 1 {
+		hostConcurrency = len(targets)
+		turns = newPortTurns(len(targets))
+	}
+	if hostConcurrency > 1 {
+		opts.Verbose = verbose && len(targets) == 1
+		opts.OnOpen = serialize(opts.OnOpen)
+		opts.OnProgress = nil
+	}
+	hostCtx, cancelHosts := context.WithCancel(ctx)
+	defer cancelHosts()
+
+	results := make([]ScanResult, len(targets))
+	flips := make([]int, len(targets))
+	hostSlots := make(chan struct{}, hostConcurrency)
+	var hostWG sync.WaitGroup
+	scanStart := time.Now()
+	var scanned []string
+	totalPorts := 0
+	for i, target := range targets {
+		if len(plan[i]) == 0 {
+			// Truncated targets have no ports, so they never take a turn
+			for j := i; turns != nil && j < len(targets); j++ {
+				turns.host(j).finish()
+			}
+			break
+		}
+		hostSlots <- struct{}{}
+		if hostCtx.Err() != nil {
+			break
+		}
+		totalPorts += len(plan[i])
+		scanned = append(scanned, target.addr)
+		targetOpts := opts
+		if labelled {
+			targetOpts.OnOpen = labelAddress(opts.OnOpen, target.addr)
+		}
+		targetOpts.ProbeHost = target.probeHost
+		if turns != nil {
+			targetOpts.Turn = turns.host(i)
+		}
+
+		hostWG.Add(1)
+		go func(i int, target scanTarget) {
+			defer hostWG.Done()
+			defer func() { <-hostSlots }()
+			defer targetOpts.Turn.finish()
+			result, targetFlipped := scanHost(hostCtx, target.addr, plan[i], targetOpts, req.Verify)
+			if labelled {
+				for j := range result.OpenPorts {
+					result.OpenPorts[j].IP = target.addr
+				}
+			}
+			results[i], flips[i] = result, targetFlipped
+			// A fail-fast abort on one host stops the others too
+			if result.Err != nil {
+				cancelHosts()
+			}
+		}(i, target)
+	}
+	hostWG.Wait()
 
-	totalPorts := req.EndPort - req.StartPort + 1
-	closedPorts := totalPorts - len(openPortsInfo)
+	var total ScanResult
+	var downHosts []string
+	flipped := 0
+	for i := range results {
+		total.add(results[i])
+		flipped += flips[i]
+		if results[i].HostDown {
+			downHosts = append(downHosts, targets[i].addr)
+		}
+	}
+	total.Duration = time.Since(scanStart)
+	sortPortInfos(total.OpenPorts)
+	if req.Dedupe && labelled {
+		total.OpenPorts = dedupeAddresses(total.OpenPorts, targets)
+	}
+	sortResults(total.OpenPorts, req.SortBy)
+	sortPortInfos(total.ClosedSample)
+	if len(total.ClosedSample) > req.ClosedSample {
+		total.ClosedSample = total.ClosedSample[:req.ClosedSample]
+	}
 
-	return ScanResponse{
-		Target:          req.Host,
-		StartPort:       req.StartPort,
-		EndPort:         req.EndPort,
-		OpenPorts:       openPortsInfo,
+	closedPorts := totalPorts - total.OpenCount
+
+	response := ScanResponse{
+		Target:          name,
+		StartPort:       startPort,
+		EndPort:         endPort,
+		OpenPorts:       total.OpenPorts,
 		ClosedPorts:     closedPorts,
+		ClosedSample:    total.ClosedSample,
 		TotalPorts:      totalPorts,
-		DurationSeconds: duration.Seconds(),
+		Truncated:       truncated,
+		Shard:           shardLabel(req),
+		ErroredPorts:    total.Errored,
+		ResolvedIPs:     resolved,
+		DurationSeconds: total.Duration.Seconds(),
+		Timing: PhaseTiming{
+			ResolveMs: float64(resolveTime.Microseconds()) / 1000,
+			ScanMs:    float64(total.Duration.Microseconds()) / 1000,
+			ProbeMs:   float64(total.ProbeTime.Microseconds()) / 1000,
+		},
+		VerifyFlipped:   flipped,
+		Retried:         total.Retried,
+		RetryResolved:   total.RetryResolved,
+		PeakConcurrency: total.PeakConcurrency,
+		AvgConcurrency:  total.AvgConcurrency,
 		Timestamp:       time.Now(),
+		Meta:            meta,
+	}
+	response.ResolveMs = response.Timing.ResolveMs
+	if resolved != nil {
+		response.ScannedIPs = scanned
+	}
+	response.ConcurrencyCurve = total.ConcurrencyCurve
+	response.WildcardDNS = wildcard
+	response.IdempotencyKey = idempotencyKey
+	if req.SRV == "" {
+		if punycode, err := targetToASCII(req.Host); err == nil && punycode != req.Host {
+			response.PunycodeTarget = punycode
+		}
+	}
+	if total.Err != nil {
+		response.Error = fmt.Sprintf("scan aborted: %v", total.Err)
+	}
+	response.Status = scanStatus(ctx, truncated, total.Err)
+	local := localAddresses()
+	for _, target := range targets {
+		if IsLocalTarget(ctx, target.addr, local) {
+			response.ScanningSelf = true
+			break
+		}
+	}
+	if req.DeadHostThreshold > 0 {
+		hostUp := len(downHosts) == 0
+		response.HostUp = &hostUp
+		response.DownHosts = downHosts
+	}
+	response.SRVEndpoints = endpoints
+	ApplyOpenPortChecks(&response, req)
+
+	return response
+}
+
+// ApplyOpenPortChecks fills in the response fields derived from its open
+// ports. It can be called again after ports are added to the response.
+func ApplyOpenPortChecks(resp *ScanResponse, req ScanRequest) {
+	resp.FastestPort, resp.SlowestPort = connectExtremes(resp.OpenPorts)
+	if resp.SRVEndpoints != nil {
+		markSRVEndpoints(resp.SRVEndpoints, resp.OpenPorts)
+	}
+	if req.Allowlist != nil {
+		ApplyAllowlist(resp, req.Allowlist)
+	}
+	if len(req.ExpectBanner) > 0 {
+		ApplyBannerExpectations(resp, req.ExpectBanner)
+	}
+	if req.CertExpiryWarnDays > 0 {
+		ApplyCertExpiry(resp)
+	}
+}
+
+// scanStatus reports how a scan ended. A fail-fast abort is an error even
+// though it also cancels the remaining hosts.
+func scanStatus(ctx context.Context, truncated bool, err error) string {
+	switch {
+	case err != nil:
+		return StatusError
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return StatusDeadline
+	case ctx.Err() != nil:
+		return StatusCancelled
+	case truncated:
+		return StatusTruncated
+	}
+	return StatusComplete
+}
+
+// shardLabel describes the shard a request covers, such as "2/5"
+func shardLabel(req ScanRequest) string {
+	if req.ShardCount <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", req.ShardIndex, req.ShardCount)
+}
+
+// resolveConcurrency returns the per-host connection limit for a request.
+// ConcurrencyPerCPU, when set, takes precedence over MaxConcurrent; with
+// neither set the limit is chosen automatically.
+func resolveConcurrency(req ScanRequest) int {
+	if req.ConcurrencyPerCPU > 0 {
+		return req.ConcurrencyPerCPU * runtime.NumCPU()
+	}
+	if req.MaxConcurrent > 0 {
+		return req.MaxConcurrent
+	}
+	return autoConcurrency()
+}
+
+// autoConcurrency is autoConcurrencyPerCPU per CPU, lowered if needed so
+// the dials fit in the open file limit
+func autoConcurrency() int {
+	limit := min(autoConcurrencyPerCPU*runtime.NumCPU(), maxConcurrencyLimit)
+	if files := openFileLimit(); files > 0 {
+		limit = min(limit, max(files-fdReserve, files/2, 1))
+	}
+	return limit
+}
+
+// runProbers runs each prober against an open port. A prober that panics is
+// logged and recorded on the port instead of crashing the scan.
+func runProbers(ctx context.Context, probers []Prober, host string, info *PortInfo) {
+	for _, prober := range probers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %T panicked on port %d: %v\n", prober, info.Port, r)
+					info.Error = fmt.Sprintf("probe failed: %v", r)
+				}
+			}()
+			prober.Probe(ctx, host, info)
+		}()
+	}
+}
+
+// serviceName returns the well-known service for a port, or "unknown"
+func serviceName(port int) string {
+	if service, ok := CommonPorts[port]; ok {
+		return service
+	}
+	return "unknown"
+}
+
+// scanTarget is one address to scan. probeHost is the hostname it was
+// resolved from, which probers use for virtual hosts and SNI.
+type scanTarget struct {
+	addr      string
+	probeHost string
+	// name is the host the address was resolved from, or the address itself
+	name string
+}
+
+// dedupeAddresses collapses open ports that look identical (same port,
+// protocol, service and banner) on several addresses of the same host into
+// the first one, listing every address in FoundOn. Ports must be sorted.
+func dedupeAddresses(ports []PortInfo, targets []scanTarget) []PortInfo {
+	hostOf := make(map[string]string, len(targets))
+	for _, target := range targets {
+		hostOf[target.addr] = target.name
+	}
+	type portKey struct {
+		host, protocol, service, banner string
+		port                            int
+	}
+	index := make(map[portKey]int)
+	var deduped []PortInfo
+	for _, port := range ports {
+		key := portKey{hostOf[port.IP], port.Protocol, port.Service, port.Banner, port.Port}
+		// An address listed under two names is scanned twice; keep both
+		if i, ok := index[key]; ok && !slices.Contains(deduped[i].FoundOn, port.IP) {
+			deduped[i].FoundOn = append(deduped[i].FoundOn, port.IP)
+			continue
+		}
+		if _, ok := index[key]; !ok {
+			index[key] = len(deduped)
+		}
+		port.FoundOn = []string{port.IP}
+		deduped = append(deduped, port)
+	}
+	return deduped
+}
+
+// serialize wraps a callback so concurrent callers take turns
+func serialize(onOpen func(PortInfo)) func(PortInfo) {
+	if onOpen == nil {
+		return nil
+	}
+	var mu sync.Mutex
+	return func(info PortInfo) {
+		mu.Lock()
+		defer mu.Unlock()
+		onOpen(info)
+	}
+}
+
+// truncatePlan keeps the first limit target/port pairs, in target order
+func truncatePlan(plan [][]PortSpec, limit int) [][]PortSpec {
+	truncated := make([][]PortSpec, len(plan))
+	for i, ports := range plan {
+		n := min(len(ports), limit)
+		truncated[i] = ports[:n]
+		limit -= n
+	}
+	return truncated
+}
+
+// scanHost scans one address and, when verify is set, re-scans the closed
+// ports with a longer timeout. It also returns how many ports flipped to open.
+func scanHost(ctx context.Context, host string, ports []PortSpec, opts ScanOptions, verify bool) (ScanResult, int) {
+	result := ScanPorts(ctx, host, ports, opts)
+	if opts.Retries > 0 && result.Err == nil && !result.HostDown {
+		retryTimedOut(ctx, host, &result, opts)
+	}
+	if !verify || result.Err != nil || result.HostDown {
+		return result, 0
+	}
+
+	closed := closedPortList(ports, result.OpenPorts)
+	if len(closed) == 0 {
+		return result, 0
+	}
+	verifyOpts := opts
+	verifyOpts.Timeout = opts.Timeout * verifyTimeoutFactor
+	verifyOpts.TCP.Timeout = opts.TCP.Timeout * verifyTimeoutFactor
+	verifyOpts.UDP.Timeout = opts.UDP.Timeout * verifyTimeoutFactor
+	if opts.PortTimeouts != nil {
+		verifyOpts.PortTimeouts = make(map[int]time.Duration, len(opts.PortTimeouts))
+		for port, timeout := range opts.PortTimeouts {
+			verifyOpts.PortTimeouts[port] = timeout * verifyTimeoutFactor
+		}
+	}
+	verifyOpts.FilteredTimeout = 0
+	verifyOpts.Turn = nil
+	verifyOpts.Retries = 0
+	// The main pass already printed the start and completion lines
+	verifyOpts.Verbose = false
+	if opts.Verbose {
+		fmt.Printf("Verifying %d closed ports with a %v timeout...\n", len(closed), verifyOpts.Timeout)
+	}
+	verified := ScanPorts(ctx, host, closed, verifyOpts)
+
+	result.add(verified)
+	// The verification pass re-checked every closed port, so its sample is current
+	result.ClosedSample = verified.ClosedSample
+	sortPortInfos(result.OpenPorts)
+	return result, verified.OpenCount
+}
+
+// retryTimedOut re-dials the ports that timed out in the main pass, up to
+// opts.Retries rounds. Retries are queued rather than done inline so a slow
+// port never holds a worker during the sweep, and they run at half the
+// concurrency so they stay a low-priority trickle.
+func retryTimedOut(ctx context.Context, host string, result *ScanResult, opts ScanOptions) {
+	retryOpts := opts
+	retryOpts.MaxConcurrent = max(opts.MaxConcurrent/2, 1)
+	if opts.TCP.MaxConcurrent > 0 {
+		retryOpts.TCP.MaxConcurrent = max(opts.TCP.MaxConcurrent/2, 1)
+	}
+	if opts.UDP.MaxConcurrent > 0 {
+		retryOpts.UDP.MaxConcurrent = max(opts.UDP.MaxConcurrent/2, 1)
+	}
+	retryOpts.Verbose = false
+	retryOpts.OnProgress = nil
+	retryOpts.Turn = nil
+	retryOpts.DeadHostThreshold = 0
+	sample := result.ClosedSample
+
+	pending := result.timedOut
+	result.Retried = len(pending)
+	for attempt := range opts.Retries {
+		if len(pending) == 0 || opts.Backoff.Wait(ctx, attempt+1) != nil {
+			break
+		}
+		retry := ScanPorts(ctx, host, pending, retryOpts)
+		if ctx.Err() != nil {
+			// Ports left undialed did not answer, so nothing is counted
+			result.add(retry)
+			break
+		}
+		result.RetryResolved += len(pending) - len(retry.timedOut)
+		pending = retry.timedOut
+		retry.timedOut = nil
+		result.add(retry)
+	}
+	result.timedOut = pending
+	// The main pass already sampled these ports
+	result.ClosedSample = sample
+	sortPortInfos(result.OpenPorts)
+}
+
+// labelAddress wraps an open-port callback so results carry the dialed address
+func labelAddress(onOpen func(PortInfo), ip string) func(PortInfo) {
+	if onOpen == nil {
+		return nil
+	}
+	return func(info PortInfo) {
+		info.IP = ip
+		onOpen(info)
+	}
+}
+
+// sortPortInfos orders results by port number, then by address and protocol
+func sortPortInfos(ports []PortInfo) {
+	sort.Slice(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		if ports[i].IP != ports[j].IP {
+			return ports[i].IP < ports[j].IP
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+}
+
+// Result orders for ScanRequest.SortBy
+const (
+	// SortPort orders by port number, then address and protocol
+	SortPort = "port"
+	// SortDiscovery keeps the order the ports were found open in, so the
+	// quickest to answer come first
+	SortDiscovery = "discovery"
+	// SortService groups ports by service name, unknown services last
+	SortService = "service"
+	// SortConnectTime orders by connect time, fastest first; ports without
+	// one, such as UDP, come last
+	SortConnectTime = "connect-time"
+)
+
+// sortOrders lists the accepted SortBy values
+var sortOrders = []string{SortPort, SortDiscovery, SortService, SortConnectTime}
+
+// sortResults orders open ports for the final result. Ties fall back to
+// port order, which the ports are already in.
+func sortResults(ports []PortInfo, by string) {
+	sortPortInfos(ports)
+	switch by {
+	case SortDiscovery:
+		slices.SortStableFunc(ports, func(a, b PortInfo) int {
+			return a.found.Compare(b.found)
+		})
+	case SortService:
+		slices.SortStableFunc(ports, func(a, b PortInfo) int {
+			known := func(p PortInfo) bool { return p.Service != "" && p.Service != "unknown" }
+			if known(a) != known(b) {
+				if known(a) {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(strings.ToLower(a.Service), strings.ToLower(b.Service))
+		})
+	case SortConnectTime:
+		slices.SortStableFunc(ports, func(a, b PortInfo) int {
+			if (a.ConnectMs > 0) != (b.ConnectMs > 0) {
+				if a.ConnectMs > 0 {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(a.ConnectMs, b.ConnectMs)
+		})
+	}
+}
+
+// autoProgressInterval reports progress roughly every 1% of the scan
+func autoProgressInterval(totalPorts int) int {
+	if totalPorts < 100 {
+		return 1
+	}
+	return totalPorts / 100
+}
+
+// PortRange returns every port from start to end inclusive
+func PortRange(start, end int) []int {
+	if end < start {
+		return nil
+	}
+	ports := make([]int, 0, end-start+1)
+	for p := start; p <= end; p++ {
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// closedPortList returns the ports that are not present in the open results
+func closedPortList(ports []PortSpec, open []PortInfo) []PortSpec {
+	isOpen := make(map[PortSpec]bool, len(open))
+	for _, info := range open {
+		isOpen[PortSpec{Port: info.Port, Protocol: info.Protocol}] = true
+	}
+	var closed []PortSpec
+	for _, p := range ports {
+		if !isOpen[p] {
+			closed = append(closed, p)
+		}
+	}
+	return closed
+}
+
+// connectExtremes returns the open ports with the lowest and highest connect times
+func connectExtremes(open []PortInfo) (*PortTiming, *PortTiming) {
+	if len(open) == 0 {
+		return nil, nil
+	}
+	fastest, slowest := open[0], open[0]
+	for _, info := range open[1:] {
+		if info.ConnectMs < fastest.ConnectMs {
+			fastest = info
+		}
+		if info.ConnectMs > slowest.ConnectMs {
+			slowest = info
+		}
 	}
+	return &PortTiming{Port: fastest.Port, ConnectMs: fastest.ConnectMs},
+		&PortTiming{Port: slowest.Port, ConnectMs: slowest.ConnectMs}
 }
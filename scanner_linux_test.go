@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// shrinkBacklog re-listens on the socket with the smallest accept queue, so
+// a single pending connection fills it
+func shrinkBacklog(l net.Listener) error {
+	raw, err := l.(*net.TCPListener).SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = raw.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), 0)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// shrinkBacklog is only implemented on Linux, where a full accept queue
+// makes the kernel drop SYNs
+func shrinkBacklog(net.Listener) error {
+	return errors.New("accept queue size cannot be changed on this platform")
+}
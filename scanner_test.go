@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// listenerKind is how a test listener treats the connections it gets
+type listenerKind int
+
+const (
+	// listenOpen accepts connections and keeps them open until the test ends
+	listenOpen listenerKind = iota
+	// listenAcceptClose accepts each connection and closes it at once
+	listenAcceptClose
+	// listenHang never completes a handshake, so dials time out as they do
+	// against a filtering firewall
+	listenHang
+	// listenClosed leaves nothing listening on the port, so dials are refused
+	listenClosed
+	// listenGreet sends a banner line on each connection and keeps it open
+	listenGreet
+)
+
+// testListener starts a loopback listener of the given kind and returns its
+// port. Everything it opens is closed when the test ends.
+func testListener(t testing.TB, kind listenerKind) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	switch kind {
+	case listenClosed:
+		// The port was just free, so nothing else is likely to take it
+		l.Close()
+		return port
+	case listenHang:
+		if err := fillBacklog(t, l); err != nil {
+			l.Close()
+			t.Skipf("cannot make a hanging listener here: %v", err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return port
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if kind == listenAcceptClose {
+				conn.Close()
+				continue
+			}
+			if kind == listenGreet {
+				conn.Write([]byte("220 test ready\r\n"))
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return port
+}
+
+// fillBacklog shrinks a listener's accept queue and fills it with
+// connections nobody accepts, so the kernel drops any further SYNs
+func fillBacklog(t testing.TB, l net.Listener) error {
+	t.Helper()
+	if err := shrinkBacklog(l); err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: 100 * time.Millisecond}
+	for range 16 {
+		conn, err := dialer.Dial("tcp", l.Addr().String())
+		if err != nil {
+			// The queue is full: this dial hung until its timeout
+			return nil
+		}
+		t.Cleanup(func() { conn.Close() })
+	}
+	return net.ErrClosed
+}
+
+// openPorts lists the port numbers of a result's open ports
+func openPorts(result ScanResult) []int {
+	var ports []int
+	for _, port := range result.OpenPorts {
+		ports = append(ports, port.Port)
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// stateOf returns the state a result's closed sample gives a port
+func stateOf(result ScanResult, port int) string {
+	for _, p := range result.ClosedSample {
+		if p.Port == port {
+			return p.State
+		}
+	}
+	return ""
+}
+
+func TestScanPortsClassifiesListeners(t *testing.T) {
+	open := testListener(t, listenOpen)
+	acceptClose := testListener(t, listenAcceptClose)
+	closed := testListener(t, listenClosed)
+	hang := testListener(t, listenHang)
+
+	timeout := 300 * time.Millisecond
+	ports := portSpecs([]int{open, acceptClose, closed, hang}, nil)
+	start := time.Now()
+	result := ScanPorts(context.Background(), "127.0.0.1", ports, ScanOptions{
+		MaxConcurrent: 4,
+		Timeout:       timeout,
+		ClosedSample:  len(ports),
+	})
+	elapsed := time.Since(start)
+
+	// A connect scan sees a completed handshake, even one closed at once
+	want := []int{open, acceptClose}
+	slices.Sort(want)
+	if got := openPorts(result); !slices.Equal(got, want) {
+		t.Errorf("open ports = %v, want %v", got, want)
+	}
+	if got := stateOf(result, closed); got != "closed" {
+		t.Errorf("refused port state = %q, want closed", got)
+	}
+	if got := stateOf(result, hang); got != "filtered" {
+		t.Errorf("hanging port state = %q, want filtered", got)
+	}
+	if !slices.Equal(result.timedOut, []PortSpec{{Port: hang, Protocol: protoTCP}}) {
+		t.Errorf("timed out ports = %v, want only %d", result.timedOut, hang)
+	}
+	// The hanging dial must give up at the timeout rather than wait for the
+	// kernel's own connect timeout
+	if elapsed < timeout || elapsed > 3*timeout {
+		t.Errorf("scan took %v with a %v timeout", elapsed, timeout)
+	}
+}
+
+func TestScanPortsDetectsAcceptClose(t *testing.T) {
+	open := testListener(t, listenOpen)
+	acceptClose := testListener(t, listenAcceptClose)
+
+	result := ScanPorts(context.Background(), "127.0.0.1", portSpecs([]int{open, acceptClose}, nil), ScanOptions{
+		MaxConcurrent: 2,
+		Timeout:       time.Second,
+		Probers:       []Prober{resetProber{timeout: time.Second}},
+	})
+	for _, port := range result.OpenPorts {
+		if want := port.Port == acceptClose; port.Suspicious != want {
+			t.Errorf("port %d suspicious = %v, want %v", port.Port, port.Suspicious, want)
+		}
+	}
+	if len(result.OpenPorts) != 2 {
+		t.Errorf("got %d open ports, want 2", len(result.OpenPorts))
+	}
+}
+
+// panicProber is a prober with a bug that panics on every port
+type panicProber struct{}
+
+func (panicProber) Probe(context.Context, string, *PortInfo) {
+	var info *PortInfo
+	_ = info.Port
+}
+
+// slowProber takes a while on one port, recording when it started
+type slowProber struct {
+	port    int
+	started chan time.Time
+}
+
+func (p slowProber) Probe(_ context.Context, _ string, info *PortInfo) {
+	if info.Port == p.port {
+		p.started <- time.Now()
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestScanPortsDiscoveryIgnoresProbeTime(t *testing.T) {
+	slow := testListener(t, listenOpen)
+	fast := testListener(t, listenOpen)
+
+	prober := slowProber{port: slow, started: make(chan time.Time, 1)}
+	result := ScanPorts(context.Background(), "127.0.0.1", portSpecs([]int{slow, fast}, nil), ScanOptions{
+		MaxConcurrent: 1,
+		Timeout:       time.Second,
+		Probers:       []Prober{prober},
+	})
+	probeStart := <-prober.started
+
+	// The slow port was dialed first, so it was discovered first even
+	// though its probe finished last
+	sortResults(result.OpenPorts, SortDiscovery)
+	if got := openPortOrder(result); !slices.Equal(got, []int{slow, fast}) {
+		t.Errorf("discovery order = %v, want %v", got, []int{slow, fast})
+	}
+	for _, port := range result.OpenPorts {
+		if port.Port == slow && port.found.After(probeStart) {
+			t.Errorf("port found at %v, after its probe started at %v", port.found, probeStart)
+		}
+	}
+}
+
+// openPortOrder lists a result's open ports in their current order
+func openPortOrder(result ScanResult) []int {
+	var ports []int
+	for _, port := range result.OpenPorts {
+		ports = append(ports, port.Port)
+	}
+	return ports
+}
+
+// markProber records that it ran, so a test can see probers after a
+// panicking one still run
+type markProber struct{}
+
+func (markProber) Probe(_ context.Context, _ string, info *PortInfo) {
+	info.Banner = "probed"
+}
+
+func TestScanPortsSurvivesPanickingProber(t *testing.T) {
+	open := testListener(t, listenOpen)
+	closed := testListener(t, listenClosed)
+
+	done := make(chan ScanResult, 1)
+	go func() {
+		done <- ScanPorts(context.Background(), "127.0.0.1", portSpecs([]int{open, closed}, nil), ScanOptions{
+			MaxConcurrent: 2,
+			Timeout:       time.Second,
+			Probers:       []Prober{panicProber{}, markProber{}},
+		})
+	}()
+	var result ScanResult
+	select {
+	case result = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan did not finish after a prober panicked")
+	}
+
+	if len(result.OpenPorts) != 1 || result.OpenPorts[0].Port != open {
+		t.Fatalf("open ports = %v, want only %d", openPorts(result), open)
+	}
+	port := result.OpenPorts[0]
+	if !strings.HasPrefix(port.Error, "probe failed:") {
+		t.Errorf("port error = %q, want the recovered panic", port.Error)
+	}
+	if port.Banner != "probed" {
+		t.Error("probers after the panicking one did not run")
+	}
+	if len(result.Errored) != 0 {
+		t.Errorf("errored ports = %v, want none since the worker recovered", result.Errored)
+	}
+}
+
+func TestScanPortsSurvivesPanickingCallbacks(t *testing.T) {
+	var tcp []int
+	for range 3 {
+		tcp = append(tcp, testListener(t, listenOpen), testListener(t, listenClosed))
+	}
+
+	for name, opts := range map[string]ScanOptions{
+		"OnOpen":     {OnOpen: func(PortInfo) { panic("OnOpen bug") }},
+		"OnProgress": {OnProgress: func(int, int) { panic("OnProgress bug") }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.MaxConcurrent = 2
+			opts.Timeout = time.Second
+			opts.StreamOnly = true
+			done := make(chan ScanResult, 1)
+			go func() {
+				done <- ScanPorts(context.Background(), "127.0.0.1", portSpecs(tcp, nil), opts)
+			}()
+			select {
+			case result := <-done:
+				if len(result.Errored) == 0 {
+					t.Error("no ports recorded as errored by the panicking callback")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("scan hung after %s panicked", name)
+			}
+		})
+	}
+}
+
+// hangingListeners starts n hanging listeners at once, since filling each
+// accept queue waits out a dial timeout
+func hangingListeners(b *testing.B, n int) []int {
+	b.Helper()
+	listeners := make([]net.Listener, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.Fatalf("listen: %v", err)
+		}
+		b.Cleanup(func() { l.Close() })
+		listeners[i] = l
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fillBacklog(b, l)
+		}()
+	}
+	wg.Wait()
+	ports := make([]int, n)
+	for i, l := range listeners {
+		if errs[i] != nil {
+			b.Skipf("cannot make a hanging listener here: %v", errs[i])
+		}
+		ports[i] = l.Addr().(*net.TCPAddr).Port
+	}
+	return ports
+}
+
+// BenchmarkFilteredTimeout scans a mostly filtered host, 10 open and 90
+// hanging ports, with and without the filtered-port lane. Each operation is
+// one full scan.
+func BenchmarkFilteredTimeout(b *testing.B) {
+	var tcp []int
+	for range 10 {
+		tcp = append(tcp, testListener(b, listenOpen))
+	}
+	tcp = append(tcp, hangingListeners(b, 90)...)
+	ports := portSpecs(tcp, nil)
+
+	for _, filtered := range []time.Duration{0, 50 * time.Millisecond} {
+		b.Run("filtered-timeout="+filtered.String(), func(b *testing.B) {
+			for range b.N {
+				result := ScanPorts(context.Background(), "127.0.0.1", ports, ScanOptions{
+					MaxConcurrent:   10,
+					Timeout:         500 * time.Millisecond,
+					FilteredTimeout: filtered,
+				})
+				if len(result.OpenPorts) != 10 {
+					b.Fatalf("got %d open ports, want 10", len(result.OpenPorts))
+				}
+			}
+		})
+	}
+}
+
+func TestScanPortsReleasesSourcePortOnPanic(t *testing.T) {
+	first := testListener(t, listenClosed)
+	second := testListener(t, listenClosed)
+	// A one-port pool deadlocks the second dial if the first leaks its port
+	source := testListener(t, listenClosed)
+
+	done := make(chan ScanResult, 1)
+	go func() {
+		done <- ScanPorts(context.Background(), "127.0.0.1", portSpecs([]int{first, second}, nil), ScanOptions{
+			MaxConcurrent: 2,
+			Timeout:       time.Second,
+			SourcePorts:   NewSourcePortPool(source, source),
+			OnDone:        func(string, PortSpec) { panic("callback bug") },
+		})
+	}()
+	select {
+	case result := <-done:
+		if len(result.Errored) != 2 {
+			t.Errorf("errored ports = %v, want both", result.Errored)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan hung waiting for a source port a panicked worker kept")
+	}
+}
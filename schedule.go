@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minScheduleInterval prevents schedules from hammering a target
+const minScheduleInterval = 10 * time.Second
+
+// ScheduleRequest is the body accepted by POST /schedule
+type ScheduleRequest struct {
+	Scan     ScanRequest `json:"scan"`
+	Interval string      `json:"interval"`
+}
+
+// Schedule is a scan that the web server re-runs periodically
+type Schedule struct {
+	ID       string      `json:"id"`
+	Scan     ScanRequest `json:"scan"`
+	Interval string      `json:"interval"`
+	Runs     int         `json:"runs"`
+	Skipped  int         `json:"skipped"`
+	LastRun  *time.Time  `json:"last_run,omitempty"`
+
+	running bool
+	cancel  context.CancelFunc
+}
+
+// scheduler runs saved scans on a ticker until the server stops
+type scheduler struct {
+	mu        sync.Mutex
+	ctx       context.Context
+	stopAll   context.CancelFunc
+	schedules map[string]*Schedule
+	nextID    int
+	history   *scanHistory
+}
+
+func newScheduler(history *scanHistory) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{
+		ctx:       ctx,
+		stopAll:   cancel,
+		schedules: make(map[string]*Schedule),
+		history:   history,
+	}
+}
+
+// Add validates and starts a new schedule
+func (s *scheduler) Add(req ScheduleRequest) (Schedule, error) {
+	interval, err := time.ParseDuration(req.Interval)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid interval: %v", err)
+	}
+	if interval < minScheduleInterval {
+		return Schedule{}, fmt.Errorf("interval must be at least %v", minScheduleInterval)
+	}
+	if err := ValidateScanRequest(req.Scan); err != nil {
+		return Schedule{}, err
+	}
+	if err := CheckScanPolicy(s.ctx, req.Scan, scanPolicy); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	ctx, cancel := context.WithCancel(s.ctx)
+	sched := &Schedule{
+		ID:       strconv.Itoa(s.nextID),
+		Scan:     req.Scan,
+		Interval: interval.String(),
+		cancel:   cancel,
+	}
+	s.schedules[sched.ID] = sched
+
+	go s.loop(ctx, sched, interval)
+	return *sched, nil
+}
+
+// Remove stops and deletes a schedule, reporting whether it existed
+func (s *scheduler) Remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sched, ok := s.schedules[id]
+	if !ok {
+		return false
+	}
+	sched.cancel()
+	delete(s.schedules, id)
+	return true
+}
+
+// List returns a snapshot of all schedules
+func (s *scheduler) List() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Schedule, 0, len(s.schedules))
+	for _, sched := range s.schedules {
+		list = append(list, *sched)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, _ := strconv.Atoi(list[i].ID)
+		b, _ := strconv.Atoi(list[j].ID)
+		return a < b
+	})
+	return list
+}
+
+// Stop cancels every schedule and any scan they are running
+func (s *scheduler) Stop() {
+	s.stopAll()
+}
+
+// loop runs the scan immediately and then on every tick. A tick that arrives
+// while the previous run is still going is skipped rather than queued.
+func (s *scheduler) loop(ctx context.Context, sched *Schedule, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	s.trigger(ctx, sched)
+	for {
+		select {
+		case <-ticker.C:
+			s.trigger(ctx, sched)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *scheduler) trigger(ctx context.Context, sched *Schedule) {
+	s.mu.Lock()
+	if sched.running {
+		sched.Skipped++
+		s.mu.Unlock()
+		return
+	}
+	sched.running = true
+	s.mu.Unlock()
+
+	go func() {
+		response := RunScanContext(ctx, sched.Scan, false, ScanCallbacks{})
+
+		s.mu.Lock()
+		sched.running = false
+		sched.Runs++
+		sched.LastRun = &response.Timestamp
+		s.mu.Unlock()
+
+		if ctx.Err() == nil {
+			s.history.Add(HistoryEntry{ScheduleID: sched.ID, Response: response})
+		}
+	}()
+}
+
+// handleCreate serves POST /schedule
+func (s *scheduler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	sched, err := s.Add(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sched)
+}
+
+// handleList serves GET /schedules
+func (s *scheduler) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.List())
+}
+
+// handleDelete serves DELETE /schedule/{id}
+func (s *scheduler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/schedule/")
+	if !s.Remove(id) {
+		http.Error(w, "Schedule not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
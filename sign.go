@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// signaturePrefix names the algorithm in signature files
+const signaturePrefix = "hmac-sha256:"
+
+// minSignKeyBytes is the shortest key accepted for signing
+const minSignKeyBytes = 16
+
+// errSignatureMismatch means a result was altered or signed with another key
+var errSignatureMismatch = errors.New("signature does not match")
+
+// LoadSignKey reads a signing key from a file. Surrounding whitespace is
+// ignored so keys written with echo work.
+func LoadSignKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < minSignKeyBytes {
+		return nil, fmt.Errorf("%s: key must be at least %d bytes", path, minSignKeyBytes)
+	}
+	return key, nil
+}
+
+// SignResult returns the HMAC-SHA256 signature of a serialized result
+func SignResult(data, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyResult checks a signature produced by SignResult
+func VerifyResult(data, key []byte, signature string) error {
+	hexSum, ok := strings.CutPrefix(strings.TrimSpace(signature), signaturePrefix)
+	if !ok {
+		return fmt.Errorf("unsupported signature format (expected %s...)", signaturePrefix)
+	}
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return errSignatureMismatch
+	}
+	return nil
+}
+
+// signaturePath is where the signature of a result file is stored
+func signaturePath(path string) string {
+	return path + ".sig"
+}
+
+// SignFile writes the signature of a saved result next to it
+func SignFile(path string, key []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(signaturePath(path), []byte(SignResult(data, key)+"\n"), 0o644)
+}
+
+// VerifyFile checks a saved result against the signature stored next to it
+func VerifyFile(path string, key []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	signature, err := os.ReadFile(signaturePath(path))
+	if err != nil {
+		return err
+	}
+	return VerifyResult(data, key, string(signature))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// slowStartInitial is the concurrency a slow-start scan begins with
+const slowStartInitial = 5
+
+// slowStartInterval is how often the slow-start limit is re-evaluated
+const slowStartInterval = 250 * time.Millisecond
+
+// ConcurrencyStep is a change of the slow-start concurrency limit, at the
+// given time since the scan started
+type ConcurrencyStep struct {
+	ElapsedMs int64 `json:"elapsed_ms"`
+	Limit     int   `json:"limit"`
+}
+
+// slowStart limits the dials in flight to a window that starts small and
+// doubles every interval while the share of timeouts holds steady, like TCP
+// slow start. When the timeout rate jumps, which is what rate limiting and
+// overloaded firewalls look like, the window is halved. A steady rate, even
+// a high one from filtered ports, is not a reason to back off.
+type slowStart struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	max    int
+	active int
+	start  time.Time
+	// Dials and timeouts completed in the current interval, and the
+	// timeout rate of the previous one (-1 before the first)
+	dials, timeouts int
+	lastRate        float64
+	curve           []ConcurrencyStep
+}
+
+// newSlowStart creates a window that grows towards max and starts
+// re-evaluating it until ctx is done
+func newSlowStart(ctx context.Context, max int) *slowStart {
+	s := &slowStart{limit: min(slowStartInitial, max), max: max, start: time.Now(), lastRate: -1}
+	s.cond = sync.NewCond(&s.mu)
+	s.curve = []ConcurrencyStep{{ElapsedMs: 0, Limit: s.limit}}
+	go func() {
+		ticker := time.NewTicker(slowStartInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.adjust()
+			case <-ctx.Done():
+				s.mu.Lock()
+				s.cond.Broadcast()
+				s.mu.Unlock()
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// acquire waits for room in the window
+func (s *slowStart) acquire(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.active >= s.limit && ctx.Err() == nil {
+		s.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.active++
+	return nil
+}
+
+// release frees a place in the window and records whether the dial timed out
+func (s *slowStart) release(timedOut bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.dials++
+	if timedOut {
+		s.timeouts++
+	}
+	s.cond.Broadcast()
+}
+
+// adjust grows or shrinks the window from the last interval's timeouts
+func (s *slowStart) adjust() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dials == 0 {
+		return
+	}
+	rate := float64(s.timeouts) / float64(s.dials)
+	limit := s.limit
+	switch {
+	case s.lastRate >= 0 && rate > 0.25 && rate > s.lastRate+0.25:
+		limit = max(s.limit/2, 1)
+	case s.lastRate < 0 || rate <= s.lastRate+0.1:
+		limit = min(s.limit*2, s.max)
+	}
+	s.lastRate = rate
+	s.dials, s.timeouts = 0, 0
+	if limit != s.limit {
+		s.limit = limit
+		s.curve = append(s.curve, ConcurrencyStep{ElapsedMs: time.Since(s.start).Milliseconds(), Limit: limit})
+		s.cond.Broadcast()
+	}
+}
+
+// steps returns the recorded changes of the limit
+func (s *slowStart) steps() []ConcurrencyStep {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ConcurrencyStep(nil), s.curve...)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SourcePortPool hands out local ports from a fixed range for outgoing
+// connections. Ports are reused in the order they were released, so dials
+// cycle through the whole range, and Acquire waits when all are in use.
+type SourcePortPool struct {
+	free chan int
+}
+
+// ParsePortRange parses a range such as "40000-41000"
+func ParsePortRange(spec string) (int, int, error) {
+	startText, endText, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q (expected start-end)", spec)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(startText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", spec, err)
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(endText))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", spec, err)
+	}
+	if start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q: ports must be between 1 and 65535, start first", spec)
+	}
+	return start, end, nil
+}
+
+// NewSourcePortPool creates a pool holding every port in start..end
+func NewSourcePortPool(start, end int) *SourcePortPool {
+	pool := &SourcePortPool{free: make(chan int, end-start+1)}
+	for port := start; port <= end; port++ {
+		pool.free <- port
+	}
+	return pool
+}
+
+// Acquire takes the least recently used free port, waiting for one to be
+// released if the range is exhausted
+func (p *SourcePortPool) Acquire(ctx context.Context) (int, error) {
+	select {
+	case port := <-p.free:
+		return port, nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Release returns a port to the pool. It is a no-op on a nil pool.
+func (p *SourcePortPool) Release(port int) {
+	if p == nil || port == 0 {
+		return
+	}
+	p.free <- port
+}
+
+// Dialer returns a copy of base bound to the given local port for the
+// protocol. SO_REUSEADDR is set so a port can be reused while its last
+// connection is in TIME_WAIT.
+func (p *SourcePortPool) Dialer(base net.Dialer, protocol string, port int) *net.Dialer {
+	if protocol == protoUDP {
+		base.LocalAddr = &net.UDPAddr{Port: port}
+	} else {
+		base.LocalAddr = &net.TCPAddr{Port: port}
+	}
+	base.Control = reuseAddrControl
+	return &base
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// reuseAddrControl leaves the socket unchanged on platforms without
+// SO_REUSEADDR semantics matching Unix
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// reuseAddrControl sets SO_REUSEADDR on a socket before it is bound
+func reuseAddrControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,5 @@
+package main
+
+// exportSQLite appends a scan and its open ports to a SQLite database. It is
+// nil unless the binary was built with -tags sqlite.
+var exportSQLite func(path string, resp ScanResponse) error
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// SRVEndpoint is one target:port published by a DNS SRV record, with the
+// outcome of scanning it
+type SRVEndpoint struct {
+	Target   string `json:"target"`
+	Port     int    `json:"port"`
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Address  string `json:"address,omitempty"`
+	State    string `json:"state"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ResolveSRV looks up an SRV record such as _sip._tcp.example.com and
+// resolves each target to the address that will be scanned. Targets that do
+// not resolve are kept with their error so they still appear in the report.
+func ResolveSRV(ctx context.Context, name string) ([]SRVEndpoint, error) {
+	records, err := lookupSRV(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve SRV record %s: %v", name, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("SRV record %s has no targets", name)
+	}
+
+	endpoints := make([]SRVEndpoint, 0, len(records))
+	for _, record := range records {
+		endpoint := SRVEndpoint{
+			Target:   strings.TrimSuffix(record.Target, "."),
+			Port:     int(record.Port),
+			Priority: record.Priority,
+			Weight:   record.Weight,
+		}
+		addrs, err := lookupHost(ctx, endpoint.Target)
+		if err != nil {
+			endpoint.State = "unresolved"
+			endpoint.Error = err.Error()
+		} else {
+			endpoint.Address = addrs[0]
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// srvPlan groups the resolved endpoints into one scan target per target
+// host and address, each with the ports published for it. Records for
+// _udp services are scanned over UDP.
+func srvPlan(name string, endpoints []SRVEndpoint) ([]scanTarget, [][]PortSpec) {
+	protocol := protoTCP
+	if strings.Contains(strings.ToLower(name)+".", "._udp.") {
+		protocol = protoUDP
+	}
+	var targets []scanTarget
+	var plan [][]PortSpec
+	index := make(map[scanTarget]int)
+	for _, endpoint := range endpoints {
+		if endpoint.Address == "" {
+			continue
+		}
+		target := scanTarget{addr: endpoint.Address, probeHost: endpoint.Target}
+		i, ok := index[target]
+		if !ok {
+			i = len(targets)
+			index[target] = i
+			targets = append(targets, target)
+			plan = append(plan, nil)
+		}
+		plan[i] = append(plan[i], PortSpec{Port: endpoint.Port, Protocol: protocol})
+	}
+	return targets, plan
+}
+
+// markSRVEndpoints records whether each scanned endpoint was found open
+func markSRVEndpoints(endpoints []SRVEndpoint, openPorts []PortInfo) {
+	open := make(map[string]bool, len(openPorts))
+	for _, port := range openPorts {
+		open[net.JoinHostPort(port.IP, fmt.Sprint(port.Port))] = true
+	}
+	for i := range endpoints {
+		endpoint := &endpoints[i]
+		if endpoint.Address == "" {
+			continue
+		}
+		if open[net.JoinHostPort(endpoint.Address, fmt.Sprint(endpoint.Port))] {
+			endpoint.State = "open"
+		} else {
+			endpoint.State = "closed"
+		}
+	}
+}
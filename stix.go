@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// stixNamespace is the UUIDv5 namespace STIX 2.1 defines for deterministic
+// cyber-observable identifiers
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
+
+// stixTime is the timestamp layout STIX requires: UTC with milliseconds
+const stixTime = "2006-01-02T15:04:05.000Z"
+
+// stixBundle is a STIX 2.1 bundle of observed open ports
+type stixBundle struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Objects []any  `json:"objects"`
+}
+
+// stixAddress is an ipv4-addr, ipv6-addr or domain-name observable
+type stixAddress struct {
+	Type        string `json:"type"`
+	SpecVersion string `json:"spec_version"`
+	ID          string `json:"id"`
+	Value       string `json:"value"`
+}
+
+// stixTraffic is a network-traffic observable for one open port
+type stixTraffic struct {
+	Type        string   `json:"type"`
+	SpecVersion string   `json:"spec_version"`
+	ID          string   `json:"id"`
+	DstRef      string   `json:"dst_ref"`
+	DstPort     int      `json:"dst_port"`
+	Protocols   []string `json:"protocols"`
+}
+
+// stixObservedData records that the scan saw an open port
+type stixObservedData struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	FirstObserved  string   `json:"first_observed"`
+	LastObserved   string   `json:"last_observed"`
+	NumberObserved int      `json:"number_observed"`
+	ObjectRefs     []string `json:"object_refs"`
+}
+
+// FormatSTIX renders the open ports as a STIX 2.1 bundle: one observed-data
+// object per open port, referring to a network-traffic object for the port
+// and an ipv4-addr or ipv6-addr object for the host. Observable IDs are
+// derived from their contents as STIX specifies, so the same host or port
+// gets the same ID in every scan.
+func FormatSTIX(resp ScanResponse) ([]byte, error) {
+	now := time.Now().UTC().Format(stixTime)
+	first := resp.Timestamp.UTC()
+	last := first.Add(time.Duration(resp.DurationSeconds * float64(time.Second)))
+
+	bundle := stixBundle{Type: "bundle", ID: "bundle--" + uuidV4(), Objects: []any{}}
+	seen := make(map[string]bool)
+	for _, host := range nmapHosts(resp) {
+		if len(host.Ports) == 0 {
+			continue
+		}
+		address := stixAddressFor(host.IP)
+		if !seen[address.ID] {
+			seen[address.ID] = true
+			bundle.Objects = append(bundle.Objects, address)
+		}
+		for _, port := range host.Ports {
+			protocols := []string{"ipv4", nmapProtocol(port)}
+			if address.Type == "ipv6-addr" {
+				protocols[0] = "ipv6"
+			}
+			traffic := stixTraffic{
+				Type:        "network-traffic",
+				SpecVersion: "2.1",
+				DstRef:      address.ID,
+				DstPort:     port.Port,
+				Protocols:   protocols,
+			}
+			traffic.ID = stixObservableID(traffic.Type, map[string]any{
+				"dst_ref":   traffic.DstRef,
+				"dst_port":  traffic.DstPort,
+				"protocols": traffic.Protocols,
+			})
+			if !seen[traffic.ID] {
+				seen[traffic.ID] = true
+				bundle.Objects = append(bundle.Objects, traffic)
+			}
+			bundle.Objects = append(bundle.Objects, stixObservedData{
+				Type:           "observed-data",
+				SpecVersion:    "2.1",
+				ID:             "observed-data--" + uuidV4(),
+				Created:        now,
+				Modified:       now,
+				FirstObserved:  first.Format(stixTime),
+				LastObserved:   last.Format(stixTime),
+				NumberObserved: 1,
+				ObjectRefs:     []string{address.ID, traffic.ID},
+			})
+		}
+	}
+	return json.MarshalIndent(bundle, "", "  ")
+}
+
+// stixAddressFor builds the observable for a scanned address, falling back
+// to a domain-name when the result only recorded the hostname
+func stixAddressFor(host string) stixAddress {
+	kind := "domain-name"
+	if addr, err := netip.ParseAddr(host); err == nil {
+		kind = "ipv4-addr"
+		if addr.Unmap().Is6() {
+			kind = "ipv6-addr"
+		}
+	}
+	return stixAddress{
+		Type:        kind,
+		SpecVersion: "2.1",
+		ID:          stixObservableID(kind, map[string]any{"value": host}),
+		Value:       host,
+	}
+}
+
+// stixObservableID is the deterministic identifier of an observable: a
+// UUIDv5 over the canonical JSON of its ID contributing properties.
+// encoding/json sorts map keys and writes no whitespace, which matches the
+// canonical form for these simple values.
+func stixObservableID(kind string, properties map[string]any) string {
+	data, _ := json.Marshal(properties)
+	h := sha1.New()
+	h.Write(stixNamespace[:])
+	h.Write(data)
+	var id [16]byte
+	copy(id[:], h.Sum(nil))
+	id[6] = id[6]&0x0f | 0x50
+	id[8] = id[8]&0x3f | 0x80
+	return kind + "--" + formatUUID(id)
+}
+
+// uuidV4 returns a random UUID
+func uuidV4() string {
+	var id [16]byte
+	rand.Read(id[:])
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	return formatUUID(id)
+}
+
+// formatUUID writes a UUID in its usual 8-4-4-4-12 hex form
+func formatUUID(id [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// writeSTIXReport renders the results as a STIX bundle for -out
+func writeSTIXReport(w io.Writer, _ ScanRequest, resp ScanResponse) error {
+	data, err := FormatSTIX(resp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
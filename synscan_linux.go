@@ -0,0 +1,294 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// synBurstInterval paces SYN packets: at most MaxConcurrent are sent per interval
+const synBurstInterval = 10 * time.Millisecond
+
+// synPollInterval is how often the receiver checks whether the scan is over
+const synPollInterval = 50 * time.Millisecond
+
+// TCP header flags used by the SYN scanner
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// synAnswer is what came back for one port
+type synAnswer struct {
+	state string
+	rtt   time.Duration
+}
+
+// synScanAvailable reports whether raw sockets can be opened, which needs
+// root or CAP_NET_RAW
+func synScanAvailable() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return fmt.Errorf("raw sockets unavailable (run as root or grant CAP_NET_RAW): %v", err)
+	}
+	syscall.Close(fd)
+	return nil
+}
+
+// synScanPorts performs a half-open scan: it sends a SYN to each port and
+// classifies the reply as open (SYN-ACK), closed (RST) or filtered (nothing).
+// The handshake is never completed; the kernel resets the half-open
+// connection itself because it has no socket for it.
+func synScanPorts(ctx context.Context, host string, ports []int, opts ScanOptions) (ScanResult, error) {
+	dst := net.ParseIP(host).To4()
+	if dst == nil {
+		return ScanResult{}, errors.New("SYN scanning supports IPv4 addresses only")
+	}
+	src, err := sourceAddrFor(dst)
+	if err != nil {
+		return ScanResult{}, err
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("raw sockets unavailable (run as root or grant CAP_NET_RAW): %v", err)
+	}
+	defer syscall.Close(fd)
+	tv := syscall.NsecToTimeval(int64(synPollInterval))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return ScanResult{}, err
+	}
+
+	start := time.Now()
+	srcPort := 40000 + rand.IntN(20000)
+	if opts.SourcePorts != nil {
+		if srcPort, err = opts.SourcePorts.Acquire(ctx); err != nil {
+			return ScanResult{}, err
+		}
+		defer opts.SourcePorts.Release(srcPort)
+	}
+	seq := rand.Uint32()
+
+	var mu sync.Mutex
+	sent := make(map[int]time.Time, len(ports))
+	answers := make(map[int]synAnswer)
+
+	if opts.Verbose {
+		fmt.Printf("Starting SYN scan of %d ports on %s...\n", len(ports), host)
+	}
+
+	// Collect replies addressed to our source port until the scan ends
+	done := make(chan struct{})
+	var readerWG sync.WaitGroup
+	readerWG.Add(1)
+	go func() {
+		defer readerWG.Done()
+		buf := make([]byte, 4096)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				continue
+			}
+			port, flags, ok := parseSynReply(buf[:n], dst, srcPort, seq)
+			if !ok {
+				continue
+			}
+			state := ""
+			switch {
+			case flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+				state = "open"
+			case flags&tcpFlagRST != 0:
+				state = "closed"
+			default:
+				continue
+			}
+			mu.Lock()
+			if sentAt, ok := sent[port]; ok {
+				if _, seen := answers[port]; !seen {
+					answers[port] = synAnswer{state: state, rtt: time.Since(sentAt)}
+				}
+			}
+			mu.Unlock()
+		}
+	}()
+
+	// Send SYNs in bursts of MaxConcurrent, or one at a time when paced
+	sockaddr := &syscall.SockaddrInet4{}
+	copy(sockaddr.Addr[:], dst)
+	var sendErr error
+	count := 0
+send:
+	for i, port := range ports {
+		if opts.Pause.wait(ctx) != nil {
+			break
+		}
+		// Paced scans space out every SYN; others send in bursts
+		var gap time.Duration
+		switch {
+		case i == 0:
+		case opts.DialInterval > 0:
+			gap = opts.DialInterval
+		case i%opts.MaxConcurrent == 0:
+			gap = synBurstInterval
+		}
+		if gap > 0 {
+			select {
+			case <-time.After(gap):
+			case <-ctx.Done():
+				break send
+			}
+		}
+		packet := buildSYN(src, dst, srcPort, port, seq)
+		mu.Lock()
+		sent[port] = time.Now()
+		mu.Unlock()
+		if err := syscall.Sendto(fd, packet, 0, sockaddr); err != nil {
+			if opts.FailFast {
+				sendErr = fmt.Errorf("port %d: %v", port, err)
+				break send
+			}
+			continue
+		}
+		count++
+	}
+
+	// Give the last SYNs the full timeout to be answered
+	if sendErr == nil {
+		select {
+		case <-time.After(opts.Timeout):
+		case <-ctx.Done():
+		}
+	}
+	close(done)
+	readerWG.Wait()
+
+	result := ScanResult{Err: sendErr}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, port := range ports {
+		if _, ok := sent[port]; !ok {
+			continue
+		}
+		answer, ok := answers[port]
+		if !ok {
+			answer.state = "filtered"
+		}
+		if answer.state != "open" {
+			if len(result.ClosedSample) < opts.ClosedSample && ctx.Err() == nil {
+				result.ClosedSample = append(result.ClosedSample, PortInfo{Port: port, Protocol: protoTCP, Service: CommonPorts[port], State: answer.state})
+			}
+			continue
+		}
+
+		info := PortInfo{
+			Port:      port,
+			Protocol:  protoTCP,
+			Service:   serviceName(port),
+			State:     "open",
+			ConnectMs: float64(answer.rtt.Microseconds()) / 1000,
+			found:     start.Add(answer.rtt),
+		}
+		probeHost := host
+		if opts.ProbeHost != "" {
+			probeHost = opts.ProbeHost
+		}
+		runProbers(ctx, opts.Probers, probeHost, &info)
+		result.OpenCount++
+		if opts.OnOpen != nil {
+			opts.OnOpen(info)
+		}
+		if !opts.StreamOnly {
+			result.OpenPorts = append(result.OpenPorts, info)
+		}
+	}
+	result.Duration = time.Since(start)
+
+	if opts.Verbose {
+		fmt.Printf("Sent %d SYN packets, %d answered\n", count, len(answers))
+		fmt.Println("Scan complete!")
+	}
+	return result, nil
+}
+
+// sourceAddrFor finds the local address the kernel would use to reach dst
+func sourceAddrFor(dst net.IP) (net.IP, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(dst.String(), "9"))
+	if err != nil {
+		return nil, fmt.Errorf("no route to %s: %v", dst, err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
+
+// buildSYN assembles a TCP SYN segment with an MSS option. The kernel adds
+// the IP header.
+func buildSYN(src, dst net.IP, srcPort, dstPort int, seq uint32) []byte {
+	tcp := make([]byte, 24)
+	binary.BigEndian.PutUint16(tcp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	tcp[12] = 6 << 4 // data offset in 32-bit words
+	tcp[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(tcp[14:], 1024) // window
+	copy(tcp[20:], []byte{2, 4, 0x05, 0xb4})   // MSS 1460
+	binary.BigEndian.PutUint16(tcp[16:], tcpChecksum(src, dst, tcp))
+	return tcp
+}
+
+// tcpChecksum computes the TCP checksum over the IPv4 pseudo-header and segment
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	pseudo := make([]byte, 0, 12+len(segment))
+	pseudo = append(pseudo, src.To4()...)
+	pseudo = append(pseudo, dst.To4()...)
+	pseudo = append(pseudo, 0, syscall.IPPROTO_TCP, byte(len(segment)>>8), byte(len(segment)))
+	pseudo = append(pseudo, segment...)
+
+	var sum uint32
+	for i := 0; i+1 < len(pseudo); i += 2 {
+		sum += uint32(pseudo[i])<<8 | uint32(pseudo[i+1])
+	}
+	if len(pseudo)%2 == 1 {
+		sum += uint32(pseudo[len(pseudo)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+// parseSynReply extracts the port and TCP flags from an IPv4 packet if it
+// is a reply from dst to one of our SYNs
+func parseSynReply(packet []byte, dst net.IP, srcPort int, seq uint32) (int, byte, bool) {
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != syscall.IPPROTO_TCP {
+		return 0, 0, false
+	}
+	if !net.IP(packet[12:16]).Equal(dst) {
+		return 0, 0, false
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if len(packet) < ihl+20 {
+		return 0, 0, false
+	}
+	tcp := packet[ihl:]
+	if int(binary.BigEndian.Uint16(tcp[2:])) != srcPort {
+		return 0, 0, false
+	}
+	if binary.BigEndian.Uint32(tcp[8:]) != seq+1 {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint16(tcp[0:])), tcp[13], true
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// errSynUnsupported is returned on platforms without the raw socket backend
+var errSynUnsupported = errors.New("SYN scanning is only supported on Linux")
+
+// synScanAvailable reports whether SYN scanning can be used
+func synScanAvailable() error {
+	return errSynUnsupported
+}
+
+// synScanPorts is unavailable on this platform
+func synScanPorts(ctx context.Context, host string, ports []int, opts ScanOptions) (ScanResult, error) {
+	return ScanResult{}, errSynUnsupported
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// maxCIDRAddresses bounds how many addresses a single CIDR may expand to
+const maxCIDRAddresses = 65536
+
+// skipNetworkBroadcast controls whether IPv4 ranges drop their network and
+// broadcast addresses. nil leaves them out of /24 and larger ranges only.
+var skipNetworkBroadcast *bool
+
+// defaultEdgeSkipPrefix is the longest prefix whose network and broadcast
+// addresses are skipped by default
+const defaultEdgeSkipPrefix = 24
+
+// Target is one entry of an expanded target list. CIDR is set when the
+// address came from a network range. Host is always ASCII: an
+// internationalized name is converted to punycode, and Unicode keeps the
+// name as it was given.
+type Target struct {
+	Host    string
+	CIDR    string
+	Unicode string
+}
+
+// ExpandTargets turns host specs, each a hostname, an IP address, a CIDR
+// range or a comma-separated list of those, into individual targets
+func ExpandTargets(specs []string) ([]Target, error) {
+	var targets []Target
+	for _, spec := range specs {
+		for _, entry := range strings.Split(spec, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			if !strings.Contains(entry, "/") {
+				ascii, err := hostToASCII(entry)
+				if err != nil {
+					return nil, err
+				}
+				target := Target{Host: ascii}
+				if ascii != entry {
+					target.Unicode = entry
+				}
+				targets = append(targets, target)
+				continue
+			}
+			addrs, err := expandCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			for _, addr := range addrs {
+				targets = append(targets, Target{Host: addr, CIDR: entry})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// hostToASCII converts an internationalized hostname such as münchen.de to
+// its punycode form (xn--mnchen-3ya.de) for validation and resolution.
+// ASCII names are returned unchanged, so names the lookup profile would
+// reject, like those with underscores, keep working as before.
+func hostToASCII(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized hostname %q: %v", host, err)
+	}
+	return ascii, nil
+}
+
+// targetToASCII converts every hostname of a comma-separated target list
+// to punycode, leaving addresses and ranges as they are
+func targetToASCII(spec string) (string, error) {
+	entries := strings.Split(spec, ",")
+	for i, entry := range entries {
+		ascii, err := hostToASCII(strings.TrimSpace(entry))
+		if err != nil {
+			return "", err
+		}
+		entries[i] = ascii
+	}
+	return strings.Join(entries, ","), nil
+}
+
+// isASCII reports whether s has no bytes outside 7-bit ASCII
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// expandCIDR lists every address in a network range, leaving out the
+// network and broadcast addresses of IPv4 ranges when skipEdges says so
+func expandCIDR(cidr string) ([]string, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", cidr)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("CIDR %s is larger than %d addresses", cidr, maxCIDRAddresses)
+	}
+
+	var addrs []string
+	for ip := ip.Mask(network.Mask); network.Contains(ip); ip = nextIP(ip) {
+		addrs = append(addrs, ip.String())
+	}
+	if skipEdges(ones, bits) {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+	return addrs, nil
+}
+
+// skipEdges reports whether a range of the given prefix length drops its
+// first and last address. IPv6 has no broadcast address, and /31 and /32
+// ranges have no addresses to spare (RFC 3021), so they are never trimmed.
+func skipEdges(ones, bits int) bool {
+	if bits != 8*net.IPv4len || ones > 30 {
+		return false
+	}
+	if skipNetworkBroadcast != nil {
+		return *skipNetworkBroadcast
+	}
+	return ones <= defaultEdgeSkipPrefix
+}
+
+// nextIP returns the address after ip, wrapping to zero past the last one
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// IsLocalTarget reports whether the host resolves to loopback or to one of
+// this machine's addresses, as listed by localAddresses
+func IsLocalTarget(ctx context.Context, host string, local map[string]bool) bool {
+	if isLocalhost(host) {
+		return true
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.IsLoopback() || local[addr.IP.String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// localAddresses lists the addresses assigned to this machine's interfaces.
+// Callers checking many targets list them once and reuse the set.
+func localAddresses() map[string]bool {
+	local := make(map[string]bool)
+	if ifaceAddrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range ifaceAddrs {
+			if ipNet, ok := a.(*net.IPNet); ok {
+				local[ipNet.IP.String()] = true
+			}
+		}
+	}
+	return local
+}
@@ -0,0 +1,66 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Offsets into the kernel's struct tcp_info (linux/tcp.h). tcpi_snd_wnd
+// was added in Linux 5.4; older kernels return a shorter struct.
+const (
+	tcpInfoOptions   = 5
+	tcpInfoWscale    = 6
+	tcpInfoSndMSS    = 16
+	tcpInfoRTT       = 68
+	tcpInfoSndWnd    = 228
+	tcpInfoSize      = 232
+	tcpOptTimestamps = 1
+	tcpOptSACK       = 2
+	tcpOptWscale     = 4
+	tcpOptECN        = 8
+)
+
+// readTCPInfo reads what the kernel negotiated with the peer of an
+// established connection through TCP_INFO, or nil if it is unavailable
+func readTCPInfo(conn net.Conn) *TCPInfo {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var buf [tcpInfoSize]byte
+	size := uint32(len(buf))
+	var sockErr syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, sockErr = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil || sockErr != 0 || size < tcpInfoRTT+4 {
+		return nil
+	}
+
+	options := buf[tcpInfoOptions]
+	info := &TCPInfo{
+		MSS:        int(binary.NativeEndian.Uint32(buf[tcpInfoSndMSS:])),
+		RTTMicros:  int(binary.NativeEndian.Uint32(buf[tcpInfoRTT:])),
+		Timestamps: options&tcpOptTimestamps != 0,
+		SACK:       options&tcpOptSACK != 0,
+		ECN:        options&tcpOptECN != 0,
+	}
+	if options&tcpOptWscale != 0 {
+		// The low nibble is the scale the peer announced
+		scale := int(buf[tcpInfoWscale] & 0x0f)
+		info.WindowScale = &scale
+	}
+	if size >= tcpInfoSndWnd+4 {
+		info.PeerWindow = int(binary.NativeEndian.Uint32(buf[tcpInfoSndWnd:]))
+	}
+	return info
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// readTCPInfo is unavailable on this platform, so -tcp-info reports nothing
+func readTCPInfo(conn net.Conn) *TCPInfo {
+	return nil
+}
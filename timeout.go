@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeoutOverrideFlag collects -timeout-override port:ms pairs, given
+// comma-separated or as repeated flags
+type timeoutOverrideFlag map[int]int
+
+// String implements flag.Value
+func (f timeoutOverrideFlag) String() string {
+	specs := make([]string, 0, len(f))
+	for port, ms := range f {
+		specs = append(specs, fmt.Sprintf("%d:%d", port, ms))
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, ",")
+}
+
+// Set implements flag.Value
+func (f timeoutOverrideFlag) Set(value string) error {
+	for _, spec := range strings.Split(value, ",") {
+		portText, msText, ok := strings.Cut(strings.TrimSpace(spec), ":")
+		if !ok {
+			return fmt.Errorf("expected port:ms, got %q", spec)
+		}
+		port, err := strconv.Atoi(portText)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %q", portText)
+		}
+		ms, err := strconv.Atoi(msText)
+		if err != nil || ms <= 0 {
+			return fmt.Errorf("invalid timeout %q for port %d (expected milliseconds)", msText, port)
+		}
+		f[port] = ms
+	}
+	return nil
+}
+
+// portTimeouts converts a request's per-port overrides to durations
+func portTimeouts(overrides map[int]int) map[int]time.Duration {
+	if len(overrides) == 0 {
+		return nil
+	}
+	timeouts := make(map[int]time.Duration, len(overrides))
+	for port, ms := range overrides {
+		timeouts[port] = time.Duration(ms) * time.Millisecond
+	}
+	return timeouts
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Protocols a port can be scanned over
+const (
+	protoTCP = "tcp"
+	protoUDP = "udp"
+)
+
+// maxUDPReply is how much of a UDP reply is read; any reply means open
+const maxUDPReply = 512
+
+// PortSpec is one port to scan and the protocol to scan it over
+type PortSpec struct {
+	Port     int
+	Protocol string
+}
+
+// udpPayloads are requests that make common UDP services answer. Other
+// ports get an empty datagram, which many services ignore.
+var udpPayloads = map[int][]byte{
+	// DNS: standard query for the root NS records
+	53: {0x13, 0x37, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01},
+	// NTP: version 3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// SNMP: v1 GetRequest for sysDescr.0 with community "public"
+	161: {
+		0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+		0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00,
+	},
+}
+
+// ProtocolLimits replaces the global concurrency and timeout for the ports
+// of one protocol. Zero fields fall back to the global settings.
+type ProtocolLimits struct {
+	MaxConcurrent int
+	Timeout       time.Duration
+}
+
+// set reports whether the limits replace any global setting
+func (l ProtocolLimits) set() bool {
+	return l.MaxConcurrent > 0 || l.Timeout > 0
+}
+
+// forProtocol returns the options the ports of one protocol are scanned with
+func (o ScanOptions) forProtocol(protocol string) ScanOptions {
+	limits := o.TCP
+	if protocol == protoUDP {
+		limits = o.UDP
+		o.SYN = false
+	}
+	if limits.MaxConcurrent > 0 {
+		o.MaxConcurrent = limits.MaxConcurrent
+	}
+	if limits.Timeout > 0 {
+		o.Timeout = limits.Timeout
+	}
+	o.TCP, o.UDP = ProtocolLimits{}, ProtocolLimits{}
+	return o
+}
+
+// scanByProtocol scans the TCP and UDP ports side by side, each protocol
+// with its own pool of dials, so slow UDP probes cannot hold the slots TCP
+// needs or the other way round
+func scanByProtocol(ctx context.Context, hostname string, ports []PortSpec, opts ScanOptions) ScanResult {
+	var tcp, udp []PortSpec
+	for _, spec := range ports {
+		if spec.Protocol == protoUDP {
+			udp = append(udp, spec)
+		} else {
+			tcp = append(tcp, spec)
+		}
+	}
+	if len(udp) == 0 {
+		return ScanPorts(ctx, hostname, tcp, opts.forProtocol(protoTCP))
+	}
+	if len(tcp) == 0 {
+		return ScanPorts(ctx, hostname, udp, opts.forProtocol(protoUDP))
+	}
+
+	// Progress counts both protocols, and open ports from the two scans
+	// must not reach OnOpen at once
+	onOpen := serialize(opts.OnOpen)
+	var progressMutex sync.Mutex
+	var done [2]int
+	laneOpts := func(lane int, protocol string) ScanOptions {
+		laneOpts := opts.forProtocol(protocol)
+		laneOpts.OnOpen = onOpen
+		if opts.OnProgress != nil {
+			laneOpts.OnProgress = func(n, _ int) {
+				progressMutex.Lock()
+				defer progressMutex.Unlock()
+				done[lane] = n
+				opts.OnProgress(done[0]+done[1], len(ports))
+			}
+		}
+		return laneOpts
+	}
+	tcpOpts, udpOpts := laneOpts(0, protoTCP), laneOpts(1, protoUDP)
+	// Only the TCP scan prints, so verbose lines do not interleave
+	udpOpts.Verbose = false
+
+	var udpResult ScanResult
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		udpResult = ScanPorts(ctx, hostname, udp, udpOpts)
+	}()
+	result := ScanPorts(ctx, hostname, tcp, tcpOpts)
+	wg.Wait()
+
+	// The two scans ran at once, so their time and dials overlapped
+	duration := max(result.Duration, udpResult.Duration)
+	peak := result.PeakConcurrency + udpResult.PeakConcurrency
+	result.add(udpResult)
+	result.Duration = duration
+	result.PeakConcurrency = peak
+	sortPortInfos(result.OpenPorts)
+	return result
+}
+
+// portSpecs combines TCP and UDP port lists into one scan list
+func portSpecs(tcp, udp []int) []PortSpec {
+	specs := make([]PortSpec, 0, len(tcp)+len(udp))
+	for _, port := range tcp {
+		specs = append(specs, PortSpec{Port: port, Protocol: protoTCP})
+	}
+	for _, port := range udp {
+		specs = append(specs, PortSpec{Port: port, Protocol: protoUDP})
+	}
+	return specs
+}
+
+// splitProtocols separates a scan list into TCP port numbers and UDP entries
+func splitProtocols(specs []PortSpec) ([]int, []PortSpec) {
+	var tcp []int
+	var udp []PortSpec
+	for _, spec := range specs {
+		if spec.Protocol == protoUDP {
+			udp = append(udp, spec)
+		} else {
+			tcp = append(tcp, spec.Port)
+		}
+	}
+	return tcp, udp
+}
+
+// portBounds returns the lowest and highest port in a scan list
+func portBounds(specs []PortSpec) (int, int) {
+	if len(specs) == 0 {
+		return 0, 0
+	}
+	low := slices.MinFunc(specs, func(a, b PortSpec) int { return a.Port - b.Port })
+	high := slices.MaxFunc(specs, func(a, b PortSpec) int { return a.Port - b.Port })
+	return low.Port, high.Port
+}
+
+// dialUDP sends a probe datagram and waits for the reply. A reply means the
+// port is open and the connection is returned. An ICMP port unreachable
+// surfaces as ECONNREFUSED (closed) and silence as a timeout, which is
+// either an open port ignoring the probe or a filtered one.
+func dialUDP(ctx context.Context, dialer *net.Dialer, address string, port int) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(dialer.Timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(udpPayloads[port]); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	buf := make([]byte, maxUDPReply)
+	if _, err := conn.Read(buf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -1,38 +1,261 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"regexp"
+	"runtime"
+	"slices"
+	"strings"
 )
 
 // ValidateScanRequest validates the scanning parameters
 func ValidateScanRequest(req ScanRequest) error {
-	if req.Host == "" {
-		return errors.New("host required")
+	// An SRV record supplies both the hosts and the ports
+	if req.SRV != "" {
+		if req.Host != "" {
+			return errors.New("host and srv cannot be used together")
+		}
+		if len(req.Ports) > 0 || len(req.UDPPorts) > 0 || req.KnownOnly || len(req.AlsoPorts) > 0 || len(req.ExcludePorts) > 0 || req.ShardCount > 0 {
+			return errors.New("srv cannot be combined with a port list or port filters")
+		}
+	} else if err := validateHosts(req.Host); err != nil {
+		return err
 	}
-	if net.ParseIP(req.Host) == nil {
-		hostnameRegex := `^([a-zA-Z0-9]+(-[a-zA-Z0-9]+)*\.)+[a-zA-Z]{2,}$`
-		matched, err := regexp.MatchString(hostnameRegex, req.Host)
-		if err != nil || !matched {
-			return errors.New("invalid hostname or IP address")
+	if req.MaxConcurrent < 0 {
+		return errors.New("max concurrent cannot be negative (use 0 for automatic)")
+	}
+	if req.MaxConcurrent > maxConcurrencyLimit {
+		return fmt.Errorf("max concurrent cannot exceed %d", maxConcurrencyLimit)
+	}
+	if req.ConcurrencyPerCPU < 0 {
+		return errors.New("concurrency per CPU cannot be negative")
+	}
+	if n := req.ConcurrencyPerCPU * runtime.NumCPU(); n > maxConcurrencyLimit {
+		return fmt.Errorf("concurrency per CPU gives %d connections on %d CPUs, exceeding the limit of %d",
+			n, runtime.NumCPU(), maxConcurrencyLimit)
+	}
+	for _, n := range []int{req.TCPConcurrency, req.UDPConcurrency} {
+		if n < 0 {
+			return errors.New("per-protocol concurrency cannot be negative")
+		}
+		if n > maxConcurrencyLimit {
+			return fmt.Errorf("per-protocol concurrency cannot exceed %d", maxConcurrencyLimit)
+		}
+	}
+	if err := validateIdempotencyKey(req.IdempotencyKey); err != nil {
+		return err
+	}
+	if req.TCPTimeoutMs < 0 || req.UDPTimeoutMs < 0 {
+		return errors.New("per-protocol timeouts cannot be negative")
+	}
+	if req.HostConcurrency < 0 {
+		return errors.New("host concurrency cannot be negative")
+	}
+	if req.Interleave && req.SYN {
+		return errors.New("interleave cannot be combined with syn")
+	}
+	if req.Interleave && req.TCPConcurrency+req.UDPConcurrency+req.TCPTimeoutMs+req.UDPTimeoutMs > 0 {
+		return errors.New("interleave cannot be combined with per-protocol concurrency or timeouts")
+	}
+
+	if req.SRV == "" {
+		if err := validatePorts(req); err != nil {
+			return err
+		}
+	}
+	if req.StreamOnly && req.Verify {
+		return errors.New("verify cannot be combined with stream-only mode")
+	}
+	if req.StreamOnly && req.Allowlist != nil {
+		return errors.New("allowlist cannot be combined with stream-only mode")
+	}
+	for _, p := range req.Allowlist {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("allowlist port %d must be between 1 and 65535", p)
+		}
+	}
+	for p := range req.ExpectBanner {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("expected banner port %d must be between 1 and 65535", p)
+		}
+	}
+	for p, ms := range req.TimeoutOverrides {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("timeout override port %d must be between 1 and 65535", p)
+		}
+		if ms <= 0 {
+			return fmt.Errorf("timeout override for port %d must be positive", p)
+		}
+	}
+	if req.SYN && len(req.TimeoutOverrides) > 0 {
+		return errors.New("timeout overrides cannot be combined with syn scans")
+	}
+	if _, err := compileBannerExpectations(req.ExpectBanner); err != nil {
+		return err
+	}
+	if req.StreamOnly && len(req.ExpectBanner) > 0 {
+		return errors.New("expected banners cannot be combined with stream-only mode")
+	}
+	if len(req.Proxies) > 0 {
+		if _, err := ParseProxyChain(req.Proxies); err != nil {
+			return err
+		}
+		if len(req.UDPPorts) > 0 || req.SYN {
+			return errors.New("proxies only carry TCP connect scans, not UDP or syn")
+		}
+		if req.GrabBanner || len(req.ExpectBanner) > 0 || req.MeasureTTFB || req.DetectResets || req.HTTPProbe || req.TLSProbe || req.QUICProbe {
+			return errors.New("probes cannot be combined with proxies; they would connect directly")
+		}
+	}
+	if req.SourcePorts != "" {
+		if _, _, err := ParsePortRange(req.SourcePorts); err != nil {
+			return fmt.Errorf("source ports: %v", err)
+		}
+	}
+	if req.ShardCount < 0 || (req.ShardCount > 0 && (req.ShardIndex < 1 || req.ShardIndex > req.ShardCount)) {
+		return errors.New("shard index must be between 1 and the shard count")
+	}
+	if req.Retries < 0 {
+		return errors.New("retries cannot be negative")
+	}
+	if err := validateBackoff(req); err != nil {
+		return err
+	}
+	if req.DeadHostThreshold < 0 {
+		return errors.New("dead host threshold cannot be negative")
+	}
+	if req.ProgressEvery < 0 {
+		return errors.New("progress interval cannot be negative")
+	}
+	if req.CertExpiryWarnDays < 0 {
+		return errors.New("certificate expiry warning days cannot be negative")
+	}
+	if req.CertExpiryWarnDays > 0 && !req.TLSProbe {
+		return errors.New("certificate expiry warnings need the TLS probe")
+	}
+	if req.ClosedSample < 0 {
+		return errors.New("closed sample size cannot be negative")
+	}
+	if req.QUICProbe && newQUICProber == nil {
+		return errors.New("QUIC probing is not built in; rebuild with -tags quic")
+	}
+	if req.VirtualHost != "" {
+		if err := validateVirtualHost(req); err != nil {
+			return err
 		}
-		_, err = net.LookupHost(req.Host)
-		if err != nil {
-			return fmt.Errorf("failed to resolve hostname: %v", err)
+	}
+	if err := validateHTTPProbe(req.HTTPMethod, req.HTTPPath, req.HTTPHeaders); err != nil {
+		return err
+	}
+	if req.FilteredTimeoutMs < 0 {
+		return errors.New("filtered timeout cannot be negative")
+	}
+	if req.MaxDials < 0 {
+		return errors.New("max dials cannot be negative")
+	}
+	if req.MaxDialsMode != "" && req.MaxDialsMode != "strict" && req.MaxDialsMode != "truncate" {
+		return errors.New("max dials mode must be strict or truncate")
+	}
+	if req.SortBy != "" && !slices.Contains(sortOrders, req.SortBy) {
+		return fmt.Errorf("sort order must be one of %s", strings.Join(sortOrders, ", "))
+	}
+	if req.JitterMs < 0 {
+		return errors.New("jitter cannot be negative")
+	}
+
+	return nil
+}
+
+// ResolveHost checks that host is an IP address or a well-formed hostname and
+// returns the addresses it resolves to
+func ResolveHost(host string) ([]string, error) {
+	host, err := hostToASCII(host)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	if !isLocalhost(host) {
+		// Labels may contain "--" for punycode (xn--mnchen-3ya), as may the TLD
+		hostnameRegex := `^([a-zA-Z0-9]+(-+[a-zA-Z0-9]+)*\.)+([a-zA-Z]{2,}|xn--[a-zA-Z0-9]+(-+[a-zA-Z0-9]+)*)$`
+		matched, err := regexp.MatchString(hostnameRegex, host)
+		if err != nil || !matched {
+			return nil, errors.New("invalid hostname or IP address")
 		}
 	}
+	addrs, err := lookupHost(context.Background(), host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve hostname: %v", err)
+	}
+	return addrs, nil
+}
 
-	if req.StartPort < 1 || req.StartPort > 65535 {
-		return errors.New("start port must be between 1 and 65535")
+// isLocalhost reports whether host is the loopback name "localhost" (or a
+// subdomain of it, per RFC 6761), which never needs a DNS lookup
+func isLocalhost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+// validateHosts checks that a host list is non-empty and every hostname resolves
+func validateHosts(host string) error {
+	if host == "" {
+		return errors.New("host required")
 	}
-	if req.EndPort < 1 || req.EndPort > 65535 {
-		return errors.New("end port must be between 1 and 65535")
+	hosts, err := ExpandTargets([]string{host})
+	if err != nil {
+		return err
 	}
-	if req.StartPort > req.EndPort {
-		return errors.New("start port cannot be greater than end port")
+	if len(hosts) == 0 {
+		return errors.New("host required")
+	}
+	for _, h := range hosts {
+		if net.ParseIP(h.Host) == nil && !isLocalhost(h.Host) {
+			if _, err := ResolveHost(h.Host); err != nil {
+				return fmt.Errorf("%s: %v", h.Host, err)
+			}
+		}
 	}
+	return nil
+}
 
+// validatePorts checks the port list or range of a request
+func validatePorts(req ScanRequest) error {
+	for _, p := range req.UDPPorts {
+		if p < 1 || p > 65535 {
+			return fmt.Errorf("UDP port %d must be between 1 and 65535", p)
+		}
+	}
+	if len(req.Ports) > 0 {
+		for _, p := range req.Ports {
+			if p < 1 || p > 65535 {
+				return fmt.Errorf("port %d must be between 1 and 65535", p)
+			}
+		}
+	} else if len(req.UDPPorts) == 0 {
+		if req.StartPort < 1 || req.StartPort > 65535 {
+			return errors.New("start port must be between 1 and 65535")
+		}
+		if req.EndPort < 1 || req.EndPort > 65535 {
+			return errors.New("end port must be between 1 and 65535")
+		}
+		if req.StartPort > req.EndPort {
+			return errors.New("start port cannot be greater than end port")
+		}
+	}
+	for _, list := range [][]int{req.AlsoPorts, req.ExcludePorts} {
+		for _, p := range list {
+			if p < 1 || p > 65535 {
+				return fmt.Errorf("port %d must be between 1 and 65535", p)
+			}
+		}
+	}
+	if len(req.UDPPorts) == 0 && len(ResolvePorts(req)) == 0 {
+		return errors.New("no ports left to scan after applying known-only and exclusions")
+	}
 	return nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Build information, injected with:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// BuildMeta returns the version details of the running binary. Commit and
+// date fall back to the VCS stamp Go embeds when they were not injected.
+func BuildMeta() ScanMeta {
+	meta := ScanMeta{Version: version, Commit: commit, BuildDate: buildDate}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		meta.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && meta.Commit == "":
+				meta.Commit = setting.Value
+			case setting.Key == "vcs.time" && meta.BuildDate == "":
+				meta.BuildDate = setting.Value
+			}
+		}
+	}
+	return meta
+}
+
+// versionString formats the build information for the version command
+func versionString() string {
+	meta := BuildMeta()
+	s := fmt.Sprintf("port-scanner %s\n", meta.Version)
+	if meta.Commit != "" {
+		s += fmt.Sprintf("  commit:     %s\n", meta.Commit)
+	}
+	if meta.BuildDate != "" {
+		s += fmt.Sprintf("  built:      %s\n", meta.BuildDate)
+	}
+	if meta.GoVersion != "" {
+		s += fmt.Sprintf("  go version: %s\n", meta.GoVersion)
+	}
+	return s
+}
+
+// secretFlags are flags whose values are replaced by CommandLine
+var secretFlags = map[string]bool{"auth": true, "sign-key": true, "key": true, "webhook": true, "webhook-key": true}
+
+// redacted stands in for secret values in a recorded command line
+const redacted = "REDACTED"
+
+// CommandLine renders an invocation for the record, with the values of
+// secretFlags and any password in a URL replaced. Arguments containing
+// spaces or quotes are quoted so the line can be pasted back into a shell.
+func CommandLine(args []string) string {
+	parts := make([]string, 0, len(args))
+	redactNext := false
+	for _, arg := range args {
+		switch {
+		case redactNext:
+			arg, redactNext = redacted, false
+		case strings.HasPrefix(arg, "-"):
+			name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			if secretFlags[name] {
+				if hasValue {
+					arg = arg[:strings.Index(arg, "=")+1] + redacted
+				} else {
+					redactNext = true
+				}
+			}
+		}
+		if u, err := url.Parse(arg); err == nil && u.User != nil {
+			if _, hasPassword := u.User.Password(); hasPassword {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				arg = u.String()
+			}
+		}
+		if arg == "" || strings.ContainsAny(arg, " \t\"'$\\") {
+			arg = strconv.Quote(arg)
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
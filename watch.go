@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// WatchEvent is one change from the baseline reported by watch mode
+type WatchEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	ScanDiff
+}
+
+// PortTransition is one port changing state between consecutive watch
+// scans, such as 8080 going from open to closed
+type PortTransition struct {
+	Timestamp time.Time `json:"timestamp"`
+	Target    string    `json:"target"`
+	IP        string    `json:"ip,omitempty"`
+	Port      int       `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Service   string    `json:"service,omitempty"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+}
+
+// Watch output formats
+const (
+	watchText        = "text"
+	watchJSON        = "json"
+	watchTransitions = "transitions"
+)
+
+// RunWatch re-scans every interval and reports each change in the open
+// ports against the baseline file, which is then replaced by the new
+// result, so each report compares consecutive scans that differed. A
+// missing baseline is created from the first scan. Changes are written in
+// format (watchText, watchJSON or watchTransitions). It returns when ctx is
+// cancelled.
+func RunWatch(ctx context.Context, req ScanRequest, baselinePath string, interval time.Duration, w io.Writer, format string) error {
+	baseline, err := LoadScanResponse(baselinePath)
+	haveBaseline := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		resp := RunScanContext(ctx, req, false, ScanCallbacks{})
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case resp.Error != "":
+			fmt.Fprintf(os.Stderr, "%s scan failed: %s\n", time.Now().Format(time.RFC3339), resp.Error)
+		case !haveBaseline:
+			if err := saveBaseline(baselinePath, resp); err != nil {
+				return err
+			}
+			baseline, haveBaseline = resp, true
+			if format == watchText {
+				fmt.Fprintf(w, "%s baseline saved to %s with %d open ports\n",
+					resp.Timestamp.Format(time.RFC3339), baselinePath, len(resp.OpenPorts))
+			}
+		default:
+			diff := DiffScans(baseline, resp)
+			if len(diff.Opened) > 0 || len(diff.Closed) > 0 {
+				writeWatchEvent(w, WatchEvent{Timestamp: resp.Timestamp, ScanDiff: diff}, format)
+				if err := saveBaseline(baselinePath, resp); err != nil {
+					return err
+				}
+				baseline = resp
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// writeWatchEvent prints a change as timestamped diff lines, as one line of
+// JSON, or as one JSON line per port transition
+func writeWatchEvent(w io.Writer, event WatchEvent, format string) {
+	switch format {
+	case watchJSON:
+		line, _ := json.Marshal(event)
+		fmt.Fprintln(w, string(line))
+		return
+	case watchTransitions:
+		for _, transition := range PortTransitions(event) {
+			line, _ := json.Marshal(transition)
+			fmt.Fprintln(w, string(line))
+		}
+		return
+	}
+	stamp := event.Timestamp.Format(time.RFC3339)
+	for _, port := range event.Opened {
+		fmt.Fprintf(w, "%s + %s (newly open)\n", stamp, formatPortLine(port))
+	}
+	for _, port := range event.Closed {
+		fmt.Fprintf(w, "%s - %s (no longer open)\n", stamp, formatPortLine(port))
+	}
+}
+
+// PortTransitions turns a change into one transition per port, openings
+// first. An opened port moves from closed to the state it was found in.
+func PortTransitions(event WatchEvent) []PortTransition {
+	transitions := make([]PortTransition, 0, len(event.Opened)+len(event.Closed))
+	add := func(port PortInfo, from, to string) {
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = protoTCP
+		}
+		transitions = append(transitions, PortTransition{
+			Timestamp: event.Timestamp,
+			Target:    event.Target,
+			IP:        port.IP,
+			Port:      port.Port,
+			Protocol:  protocol,
+			Service:   port.Service,
+			From:      from,
+			To:        to,
+		})
+	}
+	for _, port := range event.Opened {
+		add(port, "closed", openState(port))
+	}
+	for _, port := range event.Closed {
+		add(port, openState(port), "closed")
+	}
+	return transitions
+}
+
+// openState is the state an open port was reported in, "open" for results
+// saved before states were recorded
+func openState(port PortInfo) string {
+	if port.State == "" {
+		return "open"
+	}
+	return port.State
+}
+
+// saveBaseline writes a result as the new baseline. It writes a temporary
+// file and renames it so an interrupted write never corrupts the baseline.
+func saveBaseline(path string, resp ScanResponse) error {
+	data, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	})
+}
@@ -3,27 +3,89 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// defaultListenAddr is where the web server listens unless told otherwise
+const defaultListenAddr = ":8080"
+
+// defaultMaxBodyBytes caps the size of a JSON scan request
+const defaultMaxBodyBytes = 64 << 10
+
+// WebOptions configures the web server
+type WebOptions struct {
+	// Addr is the address to listen on
+	Addr string
+	// MaxBodyBytes rejects larger scan request bodies
+	MaxBodyBytes int64
+	// HistoryMaxEntries and HistoryMaxBytes bound the scan history; 0 means unbounded
+	HistoryMaxEntries int
+	HistoryMaxBytes   int
+	// GlobalConcurrency bounds the dials in flight across all scans; 0 means unbounded
+	GlobalConcurrency int
+	// CacheTTL is how long identical scan requests are answered from the
+	// result cache; 0 disables the cache
+	CacheTTL time.Duration
+	// ScanWorkers runs scans as queued jobs on this many workers; 0 runs
+	// each scan as its request arrives
+	ScanWorkers int
+	// QueueSize bounds the jobs waiting for a worker
+	QueueSize int
+}
+
+// defaultWebOptions are used when the web server is started without flags
+func defaultWebOptions() WebOptions {
+	return WebOptions{
+		Addr:              defaultListenAddr,
+		MaxBodyBytes:      defaultMaxBodyBytes,
+		HistoryMaxEntries: defaultHistoryMaxEntries,
+		HistoryMaxBytes:   defaultHistoryMaxBytes,
+		QueueSize:         defaultQueueSize,
+	}
+}
+
 // AddWebInterface sets up and starts the web server
-func AddWebInterface() {
+func AddWebInterface(opts WebOptions) {
+	addr := opts.Addr
+	maxBody := opts.MaxBodyBytes
+
 	// Create a server with a timeout
 	server := &http.Server{
-		Addr:         ":8080",
+		Addr:         addr,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
+	if opts.GlobalConcurrency > 0 {
+		globalDials = make(chan struct{}, opts.GlobalConcurrency)
+	}
+
+	history := newScanHistory(opts.HistoryMaxEntries, opts.HistoryMaxBytes)
+	schedules := newScheduler(history)
+	running := newRunningScans()
+	cache := newResultCache(opts.CacheTTL)
+	jobs := newJobQueue(opts.ScanWorkers, opts.QueueSize, running, history, cache)
+
 	// Set up handlers
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
+	// The main page is inline HTML, so only /static/ depends on the directory
+	if info, err := os.Stat("static"); err == nil && info.IsDir() {
+		fs := http.FileServer(http.Dir("static"))
+		http.Handle("/static/", http.StripPrefix("/static/", fs))
+	} else {
+		fmt.Println("Warning: static directory not found; /static/ assets will not be served")
+		http.HandleFunc("/static/", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Static assets unavailable: the server's static directory is missing", http.StatusNotFound)
+		})
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		html := `<!DOCTYPE html>
@@ -289,6 +351,10 @@ func AddWebInterface() {
                 <h2>Scan Results</h2>
                 <div class="spinner" id="spinner"></div>
                 <div id="scanSummary"></div>
+                <div id="pauseControls" style="display:none; margin-top: 12px;">
+                    <button id="pauseButton" type="button">Pause</button>
+                    <button id="resumeButton" type="button" style="display:none;">Resume</button>
+                </div>
 
                 <div class="tab-container">
                     <div class="tab-buttons">
@@ -319,10 +385,26 @@ func AddWebInterface() {
             </div>
 
             <footer>
-                Port Scanner © 2025 | A Go Web Application
+                Port Scanner {{VERSION}} © 2025 | A Go Web Application
             </footer>
 
             <script>
+                // ID of the scan in progress, used by the pause and resume buttons
+                let currentScanId = null;
+
+                async function setPaused(action) {
+                    if (!currentScanId) return;
+                    const res = await fetch('/scan/' + currentScanId + '/' + action, { method: 'POST' });
+                    if (!res.ok) return;
+                    const state = await res.json();
+                    document.getElementById('pauseButton').style.display = state.paused ? 'none' : 'inline-block';
+                    document.getElementById('resumeButton').style.display = state.paused ? 'inline-block' : 'none';
+                    document.getElementById('scanSummary').textContent = state.paused ?
+                        'Paused (connections already in flight are finishing)' : 'Scanning...';
+                }
+                document.getElementById('pauseButton').addEventListener('click', () => setPaused('pause'));
+                document.getElementById('resumeButton').addEventListener('click', () => setPaused('resume'));
+
                 document.getElementById('scanForm').addEventListener('submit', async (e) => {
                     e.preventDefault();
                     const host = document.getElementById('host').value;
@@ -349,12 +431,49 @@ func AddWebInterface() {
                                 timeout_ms: timeoutMs
                             })
                         });
-                        const data = await response.json();
+                        currentScanId = response.headers.get('X-Scan-ID');
+                        if (currentScanId) {
+                            document.getElementById('pauseControls').style.display = 'block';
+                        }
+                        let data = await response.json();
+                        // A server running a job queue answers 202; poll until the job finishes
+                        if (response.status === 202) {
+                            while (data.status === 'queued' || data.status === 'running') {
+                                if (data.status === 'queued') {
+                                    document.getElementById('scanSummary').textContent = 'Queued (position ' + data.position + ')...';
+                                }
+                                await new Promise(resolve => setTimeout(resolve, 1000));
+                                const wasQueued = data.status === 'queued';
+                                data = await (await fetch('/jobs/' + data.id)).json();
+                                if (wasQueued && data.status === 'running') {
+                                    document.getElementById('scanSummary').textContent = 'Scanning...';
+                                }
+                            }
+                            if (!data.result) {
+                                document.getElementById('scanSummary').textContent = 'Scan was cancelled before it started.';
+                                return;
+                            }
+                            data = data.result;
+                        }
+                        if (data.status === 'error' && !data.open_ports) {
+                            document.getElementById('scanSummary').textContent = 'Error: ' + data.error;
+                            return;
+                        }
+                        data.open_ports = data.open_ports || [];
 
                         // Display summary
-                        const summary = 'Scanned ' + data.total_ports + ' ports on ' + data.target + ' in ' +
+                        let summary = 'Scanned ' + data.total_ports + ' ports on ' + data.target + ' in ' +
                                         data.duration_seconds.toFixed(2) + ' seconds. Found ' +
                                         data.open_ports.length + ' open ports.';
+                        if (data.status && data.status !== 'complete') {
+                            summary += ' Status: ' + data.status + (data.error ? ' (' + data.error + ')' : '') + '.';
+                        }
+                        if (data.wildcard_dns) {
+                            summary += ' Warning: possible wildcard DNS/sinkhole.';
+                        }
+                        if (data.cached) {
+                            summary += ' (Cached result from ' + new Date(data.timestamp).toLocaleTimeString() + '.)';
+                        }
                         document.getElementById('scanSummary').textContent = summary;
 
                         // Display JSON
@@ -385,6 +504,10 @@ func AddWebInterface() {
                     } catch (error) {
                         document.getElementById('scanSummary').textContent = 'Error: ' + error.message;
                     } finally {
+                        currentScanId = null;
+                        document.getElementById('pauseControls').style.display = 'none';
+                        document.getElementById('pauseButton').style.display = 'inline-block';
+                        document.getElementById('resumeButton').style.display = 'none';
                         document.getElementById('spinner').style.display = 'none';
                     }
                 });
@@ -438,7 +561,7 @@ func AddWebInterface() {
             </script>
         </body>
         </html>`
-		fmt.Fprintf(w, html)
+		io.WriteString(w, strings.Replace(html, "{{VERSION}}", version, 1))
 	})
 
 	// Add scan endpoint
@@ -448,28 +571,103 @@ func AddWebInterface() {
 			return
 		}
 
-		var req ScanRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		req, ok := decodeScanRequest(w, r, maxBody)
+		if !ok {
 			return
 		}
 
-		if err := ValidateScanRequest(req); err != nil {
+		err := ValidateScanRequest(req)
+		if err == nil {
+			err = CheckScanPolicy(r.Context(), req, scanPolicy)
+		}
+		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			response := ScanResponse{
+				Status:    StatusError,
 				Error:     err.Error(),
 				Timestamp: time.Now(),
+				Meta:      BuildMeta(),
 			}
 			json.NewEncoder(w).Encode(response)
 			return
 		}
 
-		// Run the scan without verbose output for web interface
-		response := RunScan(req, false)
+		// Streamed scans report ports as they are found, so only whole
+		// JSON responses are cached
+		key := cacheKey(req)
+		if !wantsNDJSON(r) {
+			if response, ok := cache.Get(key); ok {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(response)
+				return
+			}
+		}
+
+		// With workers configured the scan is queued and the client polls
+		// /jobs/{id} for the result
+		if jobs != nil {
+			if wantsNDJSON(r) {
+				http.Error(w, "Streamed scans are not available with the job queue; poll /jobs/{id} instead", http.StatusBadRequest)
+				return
+			}
+			job, ok := jobs.Submit(req, key)
+			if !ok {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "Scan queue is full, try again later", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Location", "/jobs/"+job.ID)
+			w.Header().Set("X-Scan-ID", job.ID)
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(job)
+			return
+		}
 
+		// The ID lets the client pause and resume the scan while it runs
+		id, gate := running.Add()
+		defer running.Remove(id)
+		w.Header().Set("X-Scan-ID", id)
+
+		if wantsNDJSON(r) {
+			history.Add(HistoryEntry{Response: streamScanNDJSON(w, r, req, gate)})
+			return
+		}
+
+		// Send the headers straight away so the client has the scan ID, and
+		// lift the write timeout since a paused scan can outlast it
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		// Run the scan without verbose output for web interface
+		response := RunScanContext(r.Context(), req, false, ScanCallbacks{Pause: gate})
+		history.Add(HistoryEntry{Response: response})
+		cache.Put(key, response)
+
 		json.NewEncoder(w).Encode(response)
 	})
+	http.HandleFunc("/scan/", running.handlePause)
+	http.HandleFunc("/jobs/", jobs.handleJob)
+
+	// Add history endpoint
+	http.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(history.List())
+	})
+
+	// Add scheduled scan endpoints
+	http.HandleFunc("/metrics", handleMetrics(history, jobs))
+	http.HandleFunc("/schedule", schedules.handleCreate)
+	http.HandleFunc("/schedule/", schedules.handleDelete)
+	http.HandleFunc("/schedules", schedules.handleList)
 
 	// Add shutdown endpoint
 	http.HandleFunc("/shutdown", func(w http.ResponseWriter, r *http.Request) {
@@ -492,6 +690,8 @@ func AddWebInterface() {
 			defer cancel()
 
 			fmt.Println("\nShutting down server...")
+			schedules.Stop()
+			jobs.Stop()
 			if err := server.Shutdown(ctx); err != nil {
 				fmt.Printf("Server forced to shutdown: %v\n", err)
 			}
@@ -507,7 +707,11 @@ func AddWebInterface() {
 
 	// Start the server in a goroutine
 	go func() {
-		fmt.Println("Server running at http://localhost:8080")
+		displayAddr := addr
+		if strings.HasPrefix(displayAddr, ":") {
+			displayAddr = "localhost" + displayAddr
+		}
+		fmt.Printf("Server running at http://%s\n", displayAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			fmt.Printf("Error starting server: %v\n", err)
 		}
@@ -522,9 +726,69 @@ func AddWebInterface() {
 
 	// Attempt graceful shutdown
 	fmt.Println("\nShutting down server...")
+	schedules.Stop()
+	jobs.Stop()
 	if err := server.Shutdown(ctx); err != nil {
 		fmt.Printf("Server forced to shutdown: %v\n", err)
 	}
 
 	fmt.Println("Server has been shut down")
 }
+
+// ndjsonEvent is one line of a streamed scan: an open port as it is found,
+// then a single summary with the full response
+type ndjsonEvent struct {
+	Type    string        `json:"type"`
+	Port    *PortInfo     `json:"port,omitempty"`
+	Summary *ScanResponse `json:"summary,omitempty"`
+}
+
+// decodeScanRequest reads the JSON scan request of a POST /scan. Bodies
+// over maxBody get 413 and unknown fields 400; on either it writes the
+// error and returns false.
+func decodeScanRequest(w http.ResponseWriter, r *http.Request, maxBody int64) (ScanRequest, bool) {
+	var req ScanRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return ScanRequest{}, false
+		}
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return ScanRequest{}, false
+	}
+	return req, true
+}
+
+// wantsNDJSON reports whether the client asked for a streamed response
+func wantsNDJSON(r *http.Request) bool {
+	return r.URL.Query().Get("stream") == "ndjson" ||
+		strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// streamScanNDJSON runs the scan and writes each open port as its own JSON
+// line, flushing as it goes, followed by a summary line
+func streamScanNDJSON(w http.ResponseWriter, r *http.Request, req ScanRequest, gate *pauseGate) ScanResponse {
+	rc := http.NewResponseController(w)
+	// The server's WriteTimeout would cut off scans that outlast it
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	enc := json.NewEncoder(w)
+	callbacks := ScanCallbacks{
+		OnOpen: func(port PortInfo) {
+			enc.Encode(ndjsonEvent{Type: "port", Port: &port})
+			rc.Flush()
+		},
+		Pause: gate,
+	}
+	response := RunScanContext(r.Context(), req, false, callbacks)
+	enc.Encode(ndjsonEvent{Type: "summary", Summary: &response})
+	rc.Flush()
+	return response
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeScanRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"host":"127.0.0.1","start_port":1,"end_port":10}`, http.StatusOK},
+		{"oversized", `{"host":"` + strings.Repeat("a", 2*defaultMaxBodyBytes) + `"}`, http.StatusRequestEntityTooLarge},
+		{"unknown field", `{"host":"127.0.0.1","strat_port":1}`, http.StatusBadRequest},
+		{"malformed", `{"host":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			req, ok := decodeScanRequest(w, r, defaultMaxBodyBytes)
+			if ok != (tt.status == http.StatusOK) {
+				t.Fatalf("ok = %v, want %v", ok, !ok)
+			}
+			if !ok {
+				if w.Code != tt.status {
+					t.Errorf("status = %d, want %d", w.Code, tt.status)
+				}
+				return
+			}
+			if req.Host != "127.0.0.1" || req.EndPort != 10 {
+				t.Errorf("decoded %+v", req)
+			}
+		})
+	}
+}
+
+func TestDecodeScanRequestNamesUnknownField(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/scan", strings.NewReader(`{"host":"127.0.0.1","timout_ms":100}`))
+	w := httptest.NewRecorder()
+	if _, ok := decodeScanRequest(w, r, defaultMaxBodyBytes); ok {
+		t.Fatal("request with an unknown field was accepted")
+	}
+	if !strings.Contains(w.Body.String(), "timout_ms") {
+		t.Errorf("error %q does not name the unknown field", w.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookTimeout bounds each delivery attempt
+const webhookTimeout = 10 * time.Second
+
+// webhookAttempts is how many times a failed delivery is tried in total
+const webhookAttempts = 3
+
+// webhookSignatureHeader carries the HMAC-SHA256 signature of the body, in
+// the same format as the .sig files written by -sign-key
+const webhookSignatureHeader = "X-Scan-Signature"
+
+// idempotencyKeyHeader repeats the result's idempotency key on webhook
+// deliveries, so receivers can drop retries without parsing the body
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKey bounds the length of a caller-chosen idempotency key
+const maxIdempotencyKey = 255
+
+// IdempotencyKey is the key a scan's result is delivered under: the one the
+// request gives, or a hash of the request contents. The same request always
+// gets the same key, so receivers can drop re-runs and re-sends of it.
+func IdempotencyKey(req ScanRequest) string {
+	if req.IdempotencyKey != "" {
+		return req.IdempotencyKey
+	}
+	sum := sha256.Sum256([]byte(cacheKey(req)))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateIdempotencyKey checks that a key can be sent as a header value
+func validateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKey {
+		return fmt.Errorf("idempotency key cannot be longer than %d characters", maxIdempotencyKey)
+	}
+	for _, c := range key {
+		if c < 0x21 || c > 0x7e {
+			return errors.New("idempotency key must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// ParseWebhookURL checks that a webhook is an absolute http or https URL
+func ParseWebhookURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook URL: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q: expected http:// or https://", rawURL)
+	}
+	return u, nil
+}
+
+// PostWebhook POSTs a result as JSON to a webhook, signing the body when a
+// key is given. Every attempt carries the result's idempotency key.
+// Network errors, 429 and 5xx responses are retried with a growing delay;
+// other responses are final.
+func PostWebhook(ctx context.Context, rawURL string, resp ScanResponse, key []byte) error {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhookOnce(ctx, client, rawURL, body, key, resp.IdempotencyKey)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+		select {
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// postWebhookOnce makes one delivery attempt and reports whether a failure
+// is worth retrying
+func postWebhookOnce(ctx context.Context, client *http.Client, rawURL string, body, key []byte, idempotencyKey string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "port-scanner/"+version)
+	if idempotencyKey != "" {
+		req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	}
+	if key != nil {
+		req.Header.Set(webhookSignatureHeader, SignResult(body, key))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", res.Status)
+}
@@ -0,0 +1,19 @@
+package main
+
+import "testing"
+
+func TestIdempotencyKeyFollowsRequest(t *testing.T) {
+	req := ScanRequest{Host: "10.0.0.5", StartPort: 1, EndPort: 1024}
+	if IdempotencyKey(req) != IdempotencyKey(req) {
+		t.Error("the same request got different keys")
+	}
+	other := req
+	other.EndPort = 2048
+	if IdempotencyKey(req) == IdempotencyKey(other) {
+		t.Error("different requests got the same key")
+	}
+	req.IdempotencyKey = "run-7"
+	if got := IdempotencyKey(req); got != "run-7" {
+		t.Errorf("key = %q, want the one the request gives", got)
+	}
+}